@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yanzay/tbot"
+)
+
+const (
+	aclBucket       = "acl"       // "user/<id>" or "chat/<id>" -> role name
+	usernamesBucket = "usernames" // Telegram username -> user ID
+)
+
+// role is what a user may do with the bot. Each role includes the
+// rights of the ones before it.
+type role int
+
+const (
+	roleNone   role = iota // no access at all
+	roleViewer             // may look at events
+	roleEditor             // may change events
+	roleAdmin              // may grant and revoke roles
+)
+
+var roleNames = map[role]string{
+	roleNone:   "none",
+	roleViewer: "viewer",
+	roleEditor: "editor",
+	roleAdmin:  "admin",
+}
+
+// parseRole returns the role with the given English or German name.
+func parseRole(name string) (role, bool) {
+	switch strings.ToLower(name) {
+	case "viewer", "leser":
+		return roleViewer, true
+	case "editor", "bearbeiter":
+		return roleEditor, true
+	case "admin":
+		return roleAdmin, true
+	}
+	return roleNone, false
+}
+
+// commandRoles is the role each command needs. Commands not listed
+// need roleEditor, so new commands are not open by accident.
+var commandRoles = map[string]role{
+	"/start":     roleNone,
+	"/help":      roleNone,
+	"/connect":   roleViewer,
+	"/show":      roleViewer,
+	"/instances": roleViewer,
+	"/search":    roleViewer,
+	"/calendars": roleViewer,
+	"/use":       roleViewer,
+	"/timezone":  roleViewer,
+	"/reminder":  roleViewer,
+	"/free":      roleViewer,
+	"/workhours": roleViewer,
+	"/add":       roleEditor,
+	"/delete":    roleEditor,
+	"/edit":      roleEditor,
+	"/invite":    roleEditor,
+	"/uninvite":  roleEditor,
+	"/todo":      roleEditor,
+	"/slot":      roleEditor,
+	"/digest":    roleEditor,
+	"/watch":     roleEditor,
+	"/bind":      roleEditor,
+	"/grant":     roleAdmin,
+	"/revoke":    roleAdmin,
+}
+
+// callbackRoles is the role each inline button action needs.
+var callbackRoles = map[string]role{
+	"info":   roleViewer,
+	"snooze": roleViewer,
+	"slot":   roleEditor,
+	"del":    roleEditor,
+	"delok":  roleEditor,
+	"later":  roleEditor,
+	"tmrw":   roleEditor,
+}
+
+var (
+	// admins are the users from ADMINS, who are admins regardless of the ACL.
+	admins = make(map[int]bool)
+	// defaultRole is the role of users and chats without granted role.
+	defaultRole = roleNone
+)
+
+// loadACLConfig reads the admins from ADMINS, a comma separated list of
+// Telegram user IDs, and the role of everybody else from DEFAULTROLE.
+// Without ADMINS nobody could grant roles, so everybody is editor then.
+func loadACLConfig() error {
+	for _, field := range strings.Split(os.Getenv("ADMINS"), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return fmt.Errorf("ADMINS: %q is no Telegram user ID", field)
+		}
+		admins[id] = true
+	}
+
+	name := os.Getenv("DEFAULTROLE")
+	switch {
+	case name == "none":
+		defaultRole = roleNone
+	case name != "":
+		r, ok := parseRole(name)
+		if !ok {
+			return fmt.Errorf("DEFAULTROLE: unknown role %q", name)
+		}
+		defaultRole = r
+	case len(admins) == 0:
+		log.Println("ADMINS not set, everybody may change events")
+		defaultRole = roleEditor
+	}
+	return nil
+}
+
+func userACLKey(userID int) string {
+	return "user/" + strconv.Itoa(userID)
+}
+
+func chatACLKey(chatID int64) string {
+	return "chat/" + strconv.FormatInt(chatID, 10)
+}
+
+// grantedRole returns the role stored under an ACL key, ok is false if none is.
+func grantedRole(key string) (role, bool, error) {
+	var name string
+	ok, err := store.Get(aclBucket, key, &name)
+	if err != nil || !ok {
+		return roleNone, false, err
+	}
+	r, ok := parseRole(name)
+	return r, ok, nil
+}
+
+// effectiveRole returns the role of a user in a chat: the higher of the
+// roles granted to the user and to the chat, the default role if neither
+// has one. A chat gives its members at most roleEditor, granting and
+// revoking roles takes an admin granted as user or listed in ADMINS.
+func effectiveRole(chatID int64, userID int) (role, error) {
+	if admins[userID] {
+		return roleAdmin, nil
+	}
+	userRole, userOK, err := grantedRole(userACLKey(userID))
+	if err != nil {
+		return roleNone, err
+	}
+	chatRole, chatOK, err := grantedRole(chatACLKey(chatID))
+	if err != nil {
+		return roleNone, err
+	}
+	if !userOK && !chatOK {
+		return defaultRole, nil
+	}
+	//chats granted admin before it was refused for them
+	if chatRole > roleEditor {
+		chatRole = roleEditor
+	}
+	if chatRole > userRole {
+		return chatRole, nil
+	}
+	return userRole, nil
+}
+
+// requiredRole returns the role needed for a message. Messages that are
+// no commands are passed to the default handler, which ignores them.
+func requiredRole(message *tbot.Message) role {
+	if isCallback(message) {
+		action := strings.SplitN(message.CallbackQuery.Data, ":", 2)[0]
+		if r, ok := callbackRoles[action]; ok {
+			return r
+		}
+		return roleEditor
+	}
+	if !strings.HasPrefix(message.Data, "/") {
+		return roleNone
+	}
+	fields := strings.Fields(message.Data)
+	command := strings.ToLower(fields[0])
+	//looking at the todo list is no change
+	if command == "/todo" && (len(fields) == 1 || strings.EqualFold(fields[1], "list") || strings.EqualFold(fields[1], "liste")) {
+		return roleViewer
+	}
+	if r, ok := commandRoles[command]; ok {
+		return r
+	}
+	return roleEditor
+}
+
+// accessControl is the middleware checking the role of the sender
+// before any handler runs.
+func accessControl(f tbot.HandlerFunction) tbot.HandlerFunction {
+	return func(message *tbot.Message) {
+		rememberUsername(message)
+
+		required := requiredRole(message)
+		if required == roleNone {
+			f(message)
+			return
+		}
+		r, err := effectiveRole(message.ChatID, senderID(message))
+		if err != nil {
+			log.Printf("Error checking the role of user %v: %v", senderID(message), err)
+			message.Reply(internalMessage)
+			return
+		}
+		if r >= required {
+			f(message)
+			return
+		}
+
+		if isCallback(message) {
+			answerCallback(message)
+		}
+		if r == roleNone {
+			message.Replyf("Du hast keinen Zugriff auf diesen Bot. Bitte einen Admin, dir mit /grant %v viewer oder /grant %v editor Zugriff zu geben.",
+				senderID(message), senderID(message))
+			return
+		}
+		message.Replyf("Dafür brauchst du die Rolle %v, du hast %v.", roleNames[required], roleNames[r])
+	}
+}
+
+// rememberUsername keeps the user ID of the sender's username, so
+// commands can name users by @username.
+func rememberUsername(message *tbot.Message) {
+	from := sender(message)
+	if from.UserName == "" || from.ID == 0 {
+		return
+	}
+	key := strings.ToLower(from.UserName)
+	var known int
+	if ok, err := store.Get(usernamesBucket, key, &known); err == nil && ok && known == from.ID {
+		return
+	}
+	if err := store.Put(usernamesBucket, key, from.ID); err != nil {
+		log.Printf("Error remembering username of user %v: %v", from.ID, err)
+	}
+}
+
+// lookupUsername returns the ID of the user with the given @username,
+// as far as the bot has seen them.
+func lookupUsername(name string) (int, bool, error) {
+	var id int
+	ok, err := store.Get(usernamesBucket, strings.ToLower(strings.TrimPrefix(name, "@")), &id)
+	return id, ok, err
+}
+
+// aclSubject resolves who /grant and /revoke are about: "chat" for the
+// current chat, or a user given by ID or @username.
+func aclSubject(message *tbot.Message, who string) (key, name string, err error) {
+	switch {
+	case strings.EqualFold(who, "chat") || strings.EqualFold(who, "gruppe"):
+		return chatACLKey(message.ChatID), "Dieser Chat", nil
+	case strings.HasPrefix(who, "@"):
+		id, ok, err := lookupUsername(who)
+		if err != nil {
+			return "", "", err
+		}
+		if !ok {
+			return "", "", notFoundError("%v kenne ich noch nicht, bitte gib die Telegram ID an oder lass %v mir zuerst schreiben.", who, who)
+		}
+		return userACLKey(id), "Nutzer " + who, nil
+	}
+	id, err := strconv.Atoi(who)
+	if err != nil {
+		return "", "", parseError("Bitte gib den Nutzer als Telegram ID oder @username an oder chat für den ganzen Chat.")
+	}
+	return userACLKey(id), "Nutzer " + strconv.Itoa(id), nil
+}
+
+// GrantHandler gives a user or the current chat a role for
+// "/grant <id|@username|chat> <viewer|editor|admin>" and lists
+// the granted roles for "/grant".
+func GrantHandler(message *tbot.Message) error {
+	args := strings.Fields(message.Vars["args"])
+	if len(args) == 0 {
+		return listACL(message)
+	}
+	if len(args) != 2 {
+		return parseError("So vergibst du Rollen: /grant 12345 editor, /grant @anna viewer oder /grant chat editor für alle in diesem Chat.")
+	}
+	r, ok := parseRole(args[1])
+	if !ok {
+		return parseError("Die Rolle %v kenne ich nicht, es gibt viewer, editor und admin.", args[1])
+	}
+	key, name, err := aclSubject(message, args[0])
+	if err != nil {
+		return err
+	}
+	if r == roleAdmin && strings.HasPrefix(key, "chat/") {
+		return parseError("Admin kann nur einzelnen Nutzern gegeben werden, sonst könnte jeder im Chat sich selbst Rollen geben.")
+	}
+	if err := store.Put(aclBucket, key, roleNames[r]); err != nil {
+		return err
+	}
+	message.Replyf("%v hat jetzt die Rolle %v.", name, roleNames[r])
+	return nil
+}
+
+// RevokeHandler removes the role of a user or the current chat
+// for "/revoke <id|@username|chat>".
+func RevokeHandler(message *tbot.Message) error {
+	args := strings.Fields(message.Vars["args"])
+	if len(args) != 1 {
+		return parseError("Bitte gib an, wem ich die Rolle entziehen soll, z.B. /revoke 12345, /revoke @anna oder /revoke chat.")
+	}
+	key, name, err := aclSubject(message, args[0])
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(aclBucket, key); err != nil {
+		return err
+	}
+	message.Replyf("%v hat keine eigene Rolle mehr, es gilt die Standardrolle %v.", name, roleNames[defaultRole])
+	return nil
+}
+
+// listACL shows all granted roles.
+func listACL(message *tbot.Message) error {
+	keys, err := store.Keys(aclBucket)
+	if err != nil {
+		return err
+	}
+	lines := []string{"Standardrolle: " + roleNames[defaultRole]}
+	for id := range admins {
+		lines = append(lines, fmt.Sprintf("Nutzer %v: admin (ADMINS)", id))
+	}
+	for _, key := range keys {
+		r, ok, err := grantedRole(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		kind, id := splitACLKey(key)
+		lines = append(lines, fmt.Sprintf("%v %v: %v", kind, id, roleNames[r]))
+	}
+	message.Reply(strings.Join(lines, "\n"))
+	return nil
+}
+
+// splitACLKey returns what kind of subject an ACL key is about and its ID.
+func splitACLKey(key string) (string, string) {
+	parts := strings.SplitN(key, "/", 2)
+	if parts[0] == "chat" {
+		return "Chat", parts[1]
+	}
+	return "Nutzer", parts[1]
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yanzay/tbot"
+)
+
+func TestChatRoles(t *testing.T) {
+	setupHandlers(t)
+	const chat, member = int64(-100), 7
+
+	replies := send(GrantHandler, chat, testUser, tbot.MessageVars{"args": "chat admin"})
+	if len(replies) != 1 || !strings.Contains(replies[0], "nur einzelnen Nutzern") {
+		t.Errorf("/grant chat admin replied %q", replies)
+	}
+	replies = send(GrantHandler, chat, testUser, tbot.MessageVars{"args": "chat editor"})
+	if len(replies) != 1 || !strings.Contains(replies[0], "Rolle editor") {
+		t.Errorf("/grant chat editor replied %q", replies)
+	}
+	if r, err := effectiveRole(chat, member); err != nil || r != roleEditor {
+		t.Errorf("member of an editor chat has role %v, %v", roleNames[r], err)
+	}
+
+	//a chat granted admin before that was refused makes its members editors only
+	if err := store.Put(aclBucket, chatACLKey(chat), "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := effectiveRole(chat, member); err != nil || r != roleEditor {
+		t.Errorf("member of an admin chat has role %v, %v", roleNames[r], err)
+	}
+	if err := store.Put(aclBucket, userACLKey(member), "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := effectiveRole(chat, member); err != nil || r != roleAdmin {
+		t.Errorf("admin in an admin chat has role %v, %v", roleNames[r], err)
+	}
+}
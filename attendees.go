@@ -0,0 +1,268 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/yanzay/tbot"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Values of the sendUpdates parameter of the Calendar API.
+const (
+	sendUpdatesAll      = "all"
+	sendUpdatesExternal = "externalOnly"
+	sendUpdatesNone     = "none"
+)
+
+// notifyModifier matches the notify:<all|external|none> modifier choosing
+// which guests get an email about a change, e.g. notify:none.
+var notifyModifier = regexp.MustCompile(`(?i)(^|\s)notify:(\S*)`)
+
+// takeNotify removes a notify modifier from text and returns the rest of
+// the text and the sendUpdates value it stands for, sendUpdatesAll
+// without modifier.
+func takeNotify(text string) (string, string, error) {
+	match := notifyModifier.FindStringSubmatchIndex(text)
+	if match == nil {
+		return text, sendUpdatesAll, nil
+	}
+	rest := strings.TrimSpace(strings.TrimSpace(text[:match[0]]) + " " + strings.TrimSpace(text[match[1]:]))
+	switch strings.ToLower(text[match[4]:match[5]]) {
+	case "all", "alle":
+		return rest, sendUpdatesAll, nil
+	case "external", "extern", "externe":
+		return rest, sendUpdatesExternal, nil
+	case "none", "keine", "aus":
+		return rest, sendUpdatesNone, nil
+	}
+	return "", "", parseError("Bei notify: gibt es all für alle Gäste, external nur für Gäste außerhalb deiner Organisation und none für niemanden.")
+}
+
+// guestUpdates returns which guests are told about a change of evt:
+// all of them if it has guests, otherwise the default of the API.
+func guestUpdates(evt *calendar.Event) string {
+	if len(evt.Attendees) > 0 {
+		return sendUpdatesAll
+	}
+	return ""
+}
+
+// isGuest reports whether word names a guest, as email address or @username.
+func isGuest(word string) bool {
+	if strings.HasPrefix(word, "@") {
+		return len(word) > 1
+	}
+	at := strings.Index(word, "@")
+	return at > 0 && strings.Contains(word[at:], ".")
+}
+
+// splitGuests splits a list of guests like "alice@example.com, @bob und @carl".
+func splitGuests(text string) []string {
+	var guests []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if word != "und" && word != "and" {
+			guests = append(guests, word)
+		}
+	}
+	return guests
+}
+
+// takeGuests removes a list of guests following "with" or "mit" from text,
+// e.g. "mit alice@example.com, @bob", and returns the rest of the text and
+// the guests. A "mit" followed by anything else is part of the text, like
+// in "Essen mit Oma".
+func takeGuests(text string) (string, []string) {
+	words := strings.Fields(text)
+	for i, w := range words {
+		if lower := strings.ToLower(w); lower != "with" && lower != "mit" {
+			continue
+		}
+		var guests []string
+		end := i + 1
+		for ; end < len(words); end++ {
+			more := splitGuests(words[end])
+			if len(more) > 0 && !isGuest(more[0]) {
+				break
+			}
+			guests = append(guests, more...)
+		}
+		if len(guests) > 0 {
+			return strings.Join(append(words[:i:i], words[end:]...), " "), guests
+		}
+	}
+	return text, nil
+}
+
+// guestEmail returns the email address of a guest, looking up the Google
+// account linked by a Telegram user named by @username.
+func guestEmail(guest string) (string, error) {
+	if !strings.HasPrefix(guest, "@") {
+		return strings.ToLower(guest), nil
+	}
+	id, ok, err := lookupUsername(guest)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", notFoundError("%v kenne ich noch nicht, bitte gib die E-Mail Adresse an oder lass %v mir zuerst schreiben.", guest, guest)
+	}
+	email, err := userEmail(id)
+	if err == errNotConnected {
+		return "", notFoundError("%v hat keinen Google Kalender verbunden, bitte gib die E-Mail Adresse an.", guest)
+	}
+	return email, err
+}
+
+// addGuests returns attendees with the guests added that are not among them yet.
+func addGuests(attendees []*calendar.EventAttendee, guests []string) ([]*calendar.EventAttendee, error) {
+	for _, guest := range guests {
+		email, err := guestEmail(guest)
+		if err != nil {
+			return nil, err
+		}
+		if findAttendee(attendees, email) < 0 {
+			attendees = append(attendees, &calendar.EventAttendee{Email: email})
+		}
+	}
+	return attendees, nil
+}
+
+// findAttendee returns the index of the attendee with the given email address, -1 if there is none.
+func findAttendee(attendees []*calendar.EventAttendee, email string) int {
+	for i, a := range attendees {
+		if strings.EqualFold(a.Email, email) {
+			return i
+		}
+	}
+	return -1
+}
+
+// formatGuests lists the guests of an event with their answers,
+// e.g. "alice@example.com (zugesagt), bob@example.com".
+func formatGuests(attendees []*calendar.EventAttendee) string {
+	var names []string
+	for _, a := range attendees {
+		name := a.DisplayName
+		if name == "" {
+			name = a.Email
+		}
+		switch a.ResponseStatus {
+		case "accepted":
+			name += " (zugesagt)"
+		case "declined":
+			name += " (abgesagt)"
+		case "tentative":
+			name += " (vielleicht)"
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// guestArgs parses "<handle> [serie] <guests...> [notify:...]" of /invite and /uninvite.
+func guestArgs(text string, usage error) (handle string, series bool, guests []string, sendUpdates string, err error) {
+	text, sendUpdates, err = takeNotify(text)
+	if err != nil {
+		return "", false, nil, "", err
+	}
+	words := strings.Fields(text)
+	if len(words) < 2 {
+		return "", false, nil, "", usage
+	}
+	handle, words = words[0], words[1:]
+	if len(words) > 1 && isSeriesWord(words[0]) {
+		series, words = true, words[1:]
+	}
+	guests = splitGuests(strings.Join(words, " "))
+	for _, guest := range guests {
+		if !isGuest(guest) {
+			return "", false, nil, "", usage
+		}
+	}
+	return handle, series, guests, sendUpdates, nil
+}
+
+const (
+	inviteUsage = "Bitte gib den Termin und die Gäste an, z.B. /invite k3f9 alice@example.com @bob, " +
+		"mit notify:none bekommen die Gäste keine E-Mail."
+	uninviteUsage = "Bitte gib den Termin und die Gäste an, die ich ausladen soll, z.B. /uninvite k3f9 alice@example.com."
+)
+
+// InviteHandler adds guests to the event behind a handle for
+// "/invite k3f9 alice@example.com @bob", or to its whole series
+// for "/invite k3f9 serie ...".
+func InviteHandler(message *tbot.Message) error {
+	return changeGuests(message, parseError(inviteUsage), true)
+}
+
+// UninviteHandler removes guests from the event behind a handle for
+// "/uninvite k3f9 alice@example.com @bob".
+func UninviteHandler(message *tbot.Message) error {
+	return changeGuests(message, parseError(uninviteUsage), false)
+}
+
+// changeGuests invites or uninvites the guests of /invite and /uninvite,
+// usage is the error for arguments it does not understand.
+func changeGuests(message *tbot.Message, usage error, invite bool) error {
+	handle, series, guests, sendUpdates, err := guestArgs(message.Vars["args"], usage)
+	if err != nil {
+		return err
+	}
+	ref, err := resolveRef(message.ChatID, handle)
+	if err != nil {
+		return err
+	}
+	backend, _, err := chatBackend(message.ChatID, message.From.ID)
+	if err != nil {
+		return err
+	}
+	evt, err := currentEvent(backend, ref)
+	if err != nil {
+		return err
+	}
+	if series {
+		if ref, evt, err = seriesEvent(backend, ref); err != nil {
+			return err
+		}
+	}
+
+	patch := &calendar.Event{}
+	if invite {
+		attendees := append([]*calendar.EventAttendee(nil), evt.Attendees...)
+		if patch.Attendees, err = addGuests(attendees, guests); err != nil {
+			return err
+		}
+	} else {
+		patch.Attendees = evt.Attendees
+		for _, guest := range guests {
+			email, err := guestEmail(guest)
+			if err != nil {
+				return err
+			}
+			i := findAttendee(patch.Attendees, email)
+			if i < 0 {
+				return notFoundError("%v ist kein Gast von %v.", guest, evt.Summary)
+			}
+			patch.Attendees = append(patch.Attendees[:i:i], patch.Attendees[i+1:]...)
+		}
+		if len(patch.Attendees) == 0 {
+			//an empty list would be left out of the patch
+			patch.NullFields = []string{"Attendees"}
+		}
+	}
+
+	updated, err := backend.Patch(ref.CalendarID, ref.EventID, evt.Etag, patch, sendUpdates)
+	if err != nil {
+		return err
+	}
+	if _, err := issueRefs(message.ChatID, ref.CalendarID, []*calendar.Event{updated}); err != nil {
+		return err
+	}
+	if len(updated.Attendees) == 0 {
+		message.Replyf("%v hat keine Gäste mehr%v.", updated.Summary, byMember(message))
+		return nil
+	}
+	message.Replyf("Gäste von %v%v: %v", updated.Summary, byMember(message), formatGuests(updated.Attendees))
+	return nil
+}
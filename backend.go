@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// CalendarBackend is the calendar the bot handlers operate on.
+// Events are exchanged as calendar.Event values so that every backend
+// speaks the same data model as the Google Calendar API.
+type CalendarBackend interface {
+	// Upcoming returns at most max single events that end after from,
+	// ordered by their start time.
+	Upcoming(calendarID string, from time.Time, max int64) ([]*calendar.Event, error)
+	// Between returns at most max single events that end after from and
+	// start before to, ordered by their start time.
+	Between(calendarID string, from, to time.Time, max int64) ([]*calendar.Event, error)
+	// Search returns at most max single events matching query, like the q
+	// parameter of the Calendar API does, that end after from and, unless to
+	// is zero, start before to, ordered by their start time.
+	Search(calendarID, query string, from, to time.Time, max int64) ([]*calendar.Event, error)
+	// Instances returns at most max events of a recurring series that end
+	// after from, ordered by their start time.
+	Instances(calendarID, eventID string, from time.Time, max int64) ([]*calendar.Event, error)
+	// Get returns the event with the given ID.
+	Get(calendarID, eventID string) (*calendar.Event, error)
+	// Insert adds evt to the calendar and returns the stored event.
+	// sendUpdates is the parameter of the Calendar API choosing which
+	// guests get an email: "all", "externalOnly" or "none", the default
+	// of the API if empty. The same goes for Delete and Patch.
+	Insert(calendarID string, evt *calendar.Event, sendUpdates string) (*calendar.Event, error)
+	// Delete removes the event with the given ID.
+	Delete(calendarID, eventID, sendUpdates string) error
+	// Patch updates the non-empty fields of evt on the stored event.
+	// Unless etag is empty, it fails with 412 Precondition Failed if the
+	// stored event has been changed since it had that etag.
+	Patch(calendarID, eventID, etag string, evt *calendar.Event, sendUpdates string) (*calendar.Event, error)
+	// TimeZone returns the IANA name of the calendar's time zone.
+	TimeZone(calendarID string) (string, error)
+	// Calendars returns the calendars in the user's calendar list.
+	Calendars() ([]*calendar.CalendarListEntry, error)
+	// Busy returns when the given calendars are busy between from and to.
+	// The periods of all calendars are returned together and may overlap.
+	Busy(calendarIDs []string, from, to time.Time) ([]*calendar.TimePeriod, error)
+}
+
+// Watcher is implemented by backends that can report changes of a calendar
+// and push notifications about them.
+type Watcher interface {
+	// Changes returns the single events changed since syncToken, deleted ones
+	// with status "cancelled", and the token for the next call. An empty
+	// syncToken returns all events ending after from, or all events if from
+	// is zero. It fails with 410 Gone once the token has expired.
+	Changes(calendarID, syncToken string, from time.Time) ([]*calendar.Event, string, error)
+	// Watch opens channel to receive a notification on every change of the calendar.
+	Watch(calendarID string, channel *calendar.Channel) (*calendar.Channel, error)
+	// StopWatch closes a channel opened with Watch.
+	StopWatch(channelID, resourceID string) error
+}
+
+// eventTime returns the point in time of an event start or end,
+// falling back to midnight UTC for all-day dates.
+func eventTime(dt *calendar.EventDateTime) time.Time {
+	if dt == nil {
+		return time.Time{}
+	}
+	if dt.DateTime != "" {
+		t, _ := time.Parse(time.RFC3339, dt.DateTime)
+		return t
+	}
+	t, _ := time.Parse("2006-01-02", dt.Date)
+	return t
+}
+
+// matchesQuery reports whether every word of query appears in the summary,
+// description or location of evt or among its guests.
+func matchesQuery(evt *calendar.Event, query string) bool {
+	text := strings.ToLower(evt.Summary + "\n" + evt.Description + "\n" + evt.Location)
+	for _, a := range evt.Attendees {
+		text += "\n" + strings.ToLower(a.Email+" "+a.DisplayName)
+	}
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// newDateTime returns the event start or end at t. Timed values carry the
+// time zone of t, all-day values only the date of t.
+func newDateTime(t time.Time, allDay bool) *calendar.EventDateTime {
+	if allDay {
+		return &calendar.EventDateTime{Date: t.Format("2006-01-02")}
+	}
+	return &calendar.EventDateTime{DateTime: t.Format(time.RFC3339), TimeZone: t.Location().String()}
+}
+
+// backendFor returns the calendar backend acting on behalf of a Telegram user.
+func backendFor(userID int) (CalendarBackend, error) {
+	if memory != nil {
+		return memory, nil
+	}
+	u, err := loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if u.Token == nil {
+		return nil, errNotConnected
+	}
+	src := newStoringTokenSource(userID, u.Token, oauthConfig.TokenSource(context.Background(), u.Token))
+	g, err := newGoogleBackend(oauth2.NewClient(context.Background(), src))
+	if err != nil {
+		return nil, err
+	}
+	return newCachedBackend(userID, g), nil
+}
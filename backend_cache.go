@@ -0,0 +1,269 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	// cacheMaxAge is how long cached events are used before the cache is
+	// brought up to date with an incremental sync.
+	cacheMaxAge = 30 * time.Second
+	// cacheHistory is how long the cache keeps events that have ended.
+	// Reads of events ending before are passed on to the wrapped backend.
+	cacheHistory = 7 * 24 * time.Hour
+)
+
+// cachedBackend is a CalendarBackend serving reads from a local copy of
+// the single events of each calendar, which is kept up to date with the
+// sync tokens of the Calendar API. Changes are passed on to the wrapped
+// backend and make the next read sync first.
+type cachedBackend struct {
+	backend interface {
+		CalendarBackend
+		Watcher
+	}
+	userID int
+}
+
+// eventCache is the local copy of the single events of a calendar
+// ending after from, and of the calendar's time zone.
+type eventCache struct {
+	mu        sync.Mutex
+	events    map[string]*calendar.Event // event ID -> event, cancelled ones are left out
+	from      time.Time
+	timeZone  string // empty until asked for, and again after a full sync
+	syncToken string
+	synced    time.Time // zero if the cache has to sync before its next use
+}
+
+var (
+	cachesMu sync.Mutex
+	caches   = make(map[string]*eventCache) // user ID/calendar ID -> cache
+)
+
+// newCachedBackend wraps the backend of a user with the user's caches.
+// Backends that cannot report changes are returned unwrapped.
+func newCachedBackend(userID int, backend CalendarBackend) CalendarBackend {
+	watcher, ok := backend.(interface {
+		CalendarBackend
+		Watcher
+	})
+	if !ok {
+		return backend
+	}
+	return &cachedBackend{backend: watcher, userID: userID}
+}
+
+// userCache returns the cache of a user's calendar, creating an empty one if needed.
+func userCache(userID int, calendarID string) *eventCache {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+	key := strconv.Itoa(userID) + "/" + calendarID
+	c, ok := caches[key]
+	if !ok {
+		c = &eventCache{}
+		caches[key] = c
+	}
+	return c
+}
+
+// invalidateCache makes the next read of a user's calendar sync first,
+// e.g. after a push notification told that it changed.
+func invalidateCache(userID int, calendarID string) {
+	c := userCache(userID, calendarID)
+	c.mu.Lock()
+	c.synced = time.Time{}
+	c.mu.Unlock()
+	forgetUpcoming(userID)
+}
+
+// cachedEvent returns a copy of an event as the cache has it, without
+// syncing first, or nil if the cache does not have it.
+func cachedEvent(userID int, calendarID, eventID string) *calendar.Event {
+	c := userCache(userID, calendarID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if evt := c.events[eventID]; evt != nil {
+		return copyEvent(evt)
+	}
+	return nil
+}
+
+// events returns the cached events of a calendar after bringing them up to
+// date if needed. The caller must hold c.mu.
+func (cb *cachedBackend) events(c *eventCache, calendarID string) (map[string]*calendar.Event, error) {
+	if c.events != nil && time.Since(c.synced) < cacheMaxAge {
+		return c.events, nil
+	}
+
+	from := time.Now().Add(-cacheHistory)
+	full := c.syncToken == ""
+	syncFrom := time.Time{}
+	if full {
+		syncFrom = from
+	}
+	items, syncToken, err := cb.backend.Changes(calendarID, c.syncToken, syncFrom)
+	if err != nil && !full && isGone(err) {
+		//the sync token expired, start over
+		log.Printf("Sync token of calendar %v of user %v expired, syncing all events", calendarID, cb.userID)
+		items, syncToken, err = cb.backend.Changes(calendarID, "", from)
+		full = true
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if full && c.events != nil {
+		//starting over, the zone may have changed as well
+		c.timeZone = ""
+	}
+	if full || c.events == nil {
+		c.events = make(map[string]*calendar.Event)
+	}
+	for _, evt := range items {
+		if evt.Status == "cancelled" {
+			delete(c.events, evt.Id)
+		} else {
+			c.events[evt.Id] = evt
+		}
+	}
+	//the window moves along, incremental syncs also report changes of older events
+	c.from = from
+	for id, evt := range c.events {
+		if !eventTime(evt.End).After(from) {
+			delete(c.events, id)
+		}
+	}
+	c.syncToken = syncToken
+	c.synced = time.Now()
+	return c.events, nil
+}
+
+// list returns at most max cached events that end after from and,
+// unless to is zero, start before to and, unless seriesID is empty,
+// belong to that series and, unless query is empty, match it.
+// If the cache does not reach back to from, ok is false.
+func (cb *cachedBackend) list(calendarID, seriesID, query string, from, to time.Time, max int64) (items []*calendar.Event, ok bool, err error) {
+	c := userCache(cb.userID, calendarID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	events, err := cb.events(c, calendarID)
+	if err != nil || from.Before(c.from) {
+		return nil, false, err
+	}
+	for _, evt := range events {
+		if (seriesID == "" || evt.RecurringEventId == seriesID) && (query == "" || matchesQuery(evt, query)) &&
+			eventTime(evt.End).After(from) && (to.IsZero() || eventTime(evt.Start).Before(to)) {
+			items = append(items, copyEvent(evt))
+		}
+	}
+	return sortEvents(items, max), true, nil
+}
+
+func (cb *cachedBackend) Upcoming(calendarID string, from time.Time, max int64) ([]*calendar.Event, error) {
+	items, ok, err := cb.list(calendarID, "", "", from, time.Time{}, max)
+	if err != nil || ok {
+		return items, err
+	}
+	return cb.backend.Upcoming(calendarID, from, max)
+}
+
+func (cb *cachedBackend) Between(calendarID string, from, to time.Time, max int64) ([]*calendar.Event, error) {
+	items, ok, err := cb.list(calendarID, "", "", from, to, max)
+	if err != nil || ok {
+		return items, err
+	}
+	return cb.backend.Between(calendarID, from, to, max)
+}
+
+func (cb *cachedBackend) Search(calendarID, query string, from, to time.Time, max int64) ([]*calendar.Event, error) {
+	items, ok, err := cb.list(calendarID, "", query, from, to, max)
+	if err != nil || ok {
+		return items, err
+	}
+	return cb.backend.Search(calendarID, query, from, to, max)
+}
+
+func (cb *cachedBackend) Instances(calendarID, eventID string, from time.Time, max int64) ([]*calendar.Event, error) {
+	items, ok, err := cb.list(calendarID, eventID, "", from, time.Time{}, max)
+	if err != nil || ok && len(items) > 0 {
+		return items, err
+	}
+	//the cache only has single events, the API knows whether eventID is one
+	return cb.backend.Instances(calendarID, eventID, from, max)
+}
+
+// Get returns a cached event. Series themselves are not cached and are
+// fetched from the wrapped backend like events the cache does not know.
+func (cb *cachedBackend) Get(calendarID, eventID string) (*calendar.Event, error) {
+	c := userCache(cb.userID, calendarID)
+	c.mu.Lock()
+	events, err := cb.events(c, calendarID)
+	var evt *calendar.Event
+	if err == nil && events[eventID] != nil {
+		evt = copyEvent(events[eventID])
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if evt != nil {
+		return evt, nil
+	}
+	return cb.backend.Get(calendarID, eventID)
+}
+
+func (cb *cachedBackend) Insert(calendarID string, evt *calendar.Event, sendUpdates string) (*calendar.Event, error) {
+	defer invalidateCache(cb.userID, calendarID)
+	return cb.backend.Insert(calendarID, evt, sendUpdates)
+}
+
+func (cb *cachedBackend) Delete(calendarID, eventID, sendUpdates string) error {
+	defer invalidateCache(cb.userID, calendarID)
+	return cb.backend.Delete(calendarID, eventID, sendUpdates)
+}
+
+func (cb *cachedBackend) Patch(calendarID, eventID, etag string, evt *calendar.Event, sendUpdates string) (*calendar.Event, error) {
+	defer invalidateCache(cb.userID, calendarID)
+	return cb.backend.Patch(calendarID, eventID, etag, evt, sendUpdates)
+}
+
+func (cb *cachedBackend) TimeZone(calendarID string) (string, error) {
+	c := userCache(cb.userID, calendarID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timeZone == "" {
+		name, err := cb.backend.TimeZone(calendarID)
+		if err != nil {
+			return "", err
+		}
+		c.timeZone = name
+	}
+	return c.timeZone, nil
+}
+
+func (cb *cachedBackend) Calendars() ([]*calendar.CalendarListEntry, error) {
+	return cb.backend.Calendars()
+}
+
+func (cb *cachedBackend) Busy(calendarIDs []string, from, to time.Time) ([]*calendar.TimePeriod, error) {
+	return cb.backend.Busy(calendarIDs, from, to)
+}
+
+func (cb *cachedBackend) Changes(calendarID, syncToken string, from time.Time) ([]*calendar.Event, string, error) {
+	return cb.backend.Changes(calendarID, syncToken, from)
+}
+
+func (cb *cachedBackend) Watch(calendarID string, channel *calendar.Channel) (*calendar.Channel, error) {
+	return cb.backend.Watch(calendarID, channel)
+}
+
+func (cb *cachedBackend) StopWatch(channelID, resourceID string) error {
+	return cb.backend.StopWatch(channelID, resourceID)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// syncingBackend is a memory backend that reports its events as changes
+// and counts the calls to the calendar.
+type syncingBackend struct {
+	*memoryBackend
+	syncedFrom []time.Time
+	zoneCalls  int
+}
+
+func (sb *syncingBackend) Changes(calendarID, syncToken string, from time.Time) ([]*calendar.Event, string, error) {
+	sb.syncedFrom = append(sb.syncedFrom, from)
+	if from.IsZero() {
+		from = time.Unix(0, 0)
+	}
+	events, err := sb.Between(calendarID, from, time.Now().AddDate(10, 0, 0), 1000)
+	return events, "token", err
+}
+
+func (sb *syncingBackend) TimeZone(calendarID string) (string, error) {
+	sb.zoneCalls++
+	return sb.memoryBackend.TimeZone(calendarID)
+}
+
+func (sb *syncingBackend) Watch(calendarID string, channel *calendar.Channel) (*calendar.Channel, error) {
+	return channel, nil
+}
+
+func (sb *syncingBackend) StopWatch(channelID, resourceID string) error {
+	return nil
+}
+
+func TestCachedBackend(t *testing.T) {
+	const userID = 4711
+	t.Cleanup(func() {
+		cachesMu.Lock()
+		delete(caches, "4711/primary")
+		cachesMu.Unlock()
+	})
+	sb := &syncingBackend{memoryBackend: newMemoryBackend()}
+	now := time.Now().Truncate(time.Hour)
+	for summary, start := range map[string]time.Time{"Damals": now.AddDate(0, 0, -30), "Morgen": now.AddDate(0, 0, 1)} {
+		_, err := sb.Insert("primary", &calendar.Event{Summary: summary, Start: newDateTime(start, false), End: newDateTime(start.Add(time.Hour), false)}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cb := newCachedBackend(userID, sb)
+
+	upcoming, err := cb.Upcoming("primary", now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(upcoming) != 1 || upcoming[0].Summary != "Morgen" {
+		t.Errorf("Upcoming returned %v events, want Morgen", len(upcoming))
+	}
+	if len(sb.syncedFrom) != 1 || !sb.syncedFrom[0].Before(now) || sb.syncedFrom[0].Before(now.Add(-cacheHistory-time.Hour)) {
+		t.Errorf("first sync from %v, want about %v before now", sb.syncedFrom, cacheHistory)
+	}
+
+	//older events are not cached and come from the wrapped backend
+	all, err := cb.Between("primary", now.AddDate(0, 0, -60), now.AddDate(0, 0, 7), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Summary != "Damals" {
+		t.Errorf("Between of the last 60 days returned %v events, want Damals and Morgen", len(all))
+	}
+
+	for i := 0; i < 3; i++ {
+		if zone, err := cb.TimeZone("primary"); err != nil || zone != memoryTimeZone {
+			t.Fatalf("TimeZone = %q, %v", zone, err)
+		}
+	}
+	if sb.zoneCalls != 1 {
+		t.Errorf("TimeZone asked the calendar %v times, want once", sb.zoneCalls)
+	}
+}
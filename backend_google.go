@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// googleBackend is a CalendarBackend talking to the Google Calendar API.
+type googleBackend struct {
+	srv *calendar.Service
+}
+
+// newGoogleBackend creates a Google Calendar backend using an
+// authorized http client.
+func newGoogleBackend(client *http.Client) (*googleBackend, error) {
+	srv, err := calendar.New(client)
+	if err != nil {
+		return nil, err
+	}
+	return &googleBackend{srv: srv}, nil
+}
+
+func (g *googleBackend) Upcoming(calendarID string, from time.Time, max int64) ([]*calendar.Event, error) {
+	events, err := g.srv.Events.List(calendarID).ShowDeleted(false).SingleEvents(true).TimeMin(from.Format(time.RFC3339)).MaxResults(max).OrderBy("startTime").Do()
+	if err != nil {
+		return nil, err
+	}
+	return events.Items, nil
+}
+
+func (g *googleBackend) Between(calendarID string, from, to time.Time, max int64) ([]*calendar.Event, error) {
+	events, err := g.srv.Events.List(calendarID).ShowDeleted(false).SingleEvents(true).TimeMin(from.Format(time.RFC3339)).TimeMax(to.Format(time.RFC3339)).MaxResults(max).OrderBy("startTime").Do()
+	if err != nil {
+		return nil, err
+	}
+	return events.Items, nil
+}
+
+func (g *googleBackend) Search(calendarID, query string, from, to time.Time, max int64) ([]*calendar.Event, error) {
+	call := g.srv.Events.List(calendarID).ShowDeleted(false).SingleEvents(true).Q(query).TimeMin(from.Format(time.RFC3339)).MaxResults(max).OrderBy("startTime")
+	if !to.IsZero() {
+		call.TimeMax(to.Format(time.RFC3339))
+	}
+	events, err := call.Do()
+	if err != nil {
+		return nil, err
+	}
+	return events.Items, nil
+}
+
+func (g *googleBackend) Instances(calendarID, eventID string, from time.Time, max int64) ([]*calendar.Event, error) {
+	events, err := g.srv.Events.Instances(calendarID, eventID).ShowDeleted(false).TimeMin(from.Format(time.RFC3339)).MaxResults(max).Do()
+	if err != nil {
+		return nil, err
+	}
+	return events.Items, nil
+}
+
+func (g *googleBackend) Get(calendarID, eventID string) (*calendar.Event, error) {
+	return g.srv.Events.Get(calendarID, eventID).Do()
+}
+
+func (g *googleBackend) Insert(calendarID string, evt *calendar.Event, sendUpdates string) (*calendar.Event, error) {
+	call := g.srv.Events.Insert(calendarID, evt)
+	if sendUpdates != "" {
+		call.SendUpdates(sendUpdates)
+	}
+	return call.Do()
+}
+
+func (g *googleBackend) Delete(calendarID, eventID, sendUpdates string) error {
+	call := g.srv.Events.Delete(calendarID, eventID)
+	if sendUpdates != "" {
+		call.SendUpdates(sendUpdates)
+	}
+	return call.Do()
+}
+
+func (g *googleBackend) Patch(calendarID, eventID, etag string, evt *calendar.Event, sendUpdates string) (*calendar.Event, error) {
+	call := g.srv.Events.Patch(calendarID, eventID, evt)
+	if sendUpdates != "" {
+		call.SendUpdates(sendUpdates)
+	}
+	if etag != "" {
+		call.Header().Set("If-Match", etag)
+	}
+	return call.Do()
+}
+
+func (g *googleBackend) Changes(calendarID, syncToken string, from time.Time) ([]*calendar.Event, string, error) {
+	call := g.srv.Events.List(calendarID).SingleEvents(true).MaxResults(250)
+	switch {
+	case syncToken != "":
+		call.SyncToken(syncToken).ShowDeleted(true)
+	case !from.IsZero():
+		call.TimeMin(from.Format(time.RFC3339))
+	}
+	var items []*calendar.Event
+	var next string
+	err := call.Pages(context.Background(), func(events *calendar.Events) error {
+		items = append(items, events.Items...)
+		next = events.NextSyncToken
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return items, next, nil
+}
+
+func (g *googleBackend) Watch(calendarID string, channel *calendar.Channel) (*calendar.Channel, error) {
+	return g.srv.Events.Watch(calendarID, channel).Do()
+}
+
+func (g *googleBackend) StopWatch(channelID, resourceID string) error {
+	return g.srv.Channels.Stop(&calendar.Channel{Id: channelID, ResourceId: resourceID}).Do()
+}
+
+func (g *googleBackend) TimeZone(calendarID string) (string, error) {
+	cal, err := g.srv.Calendars.Get(calendarID).Do()
+	if err != nil {
+		return "", err
+	}
+	return cal.TimeZone, nil
+}
+
+func (g *googleBackend) Calendars() ([]*calendar.CalendarListEntry, error) {
+	var items []*calendar.CalendarListEntry
+	err := g.srv.CalendarList.List().Pages(context.Background(), func(list *calendar.CalendarList) error {
+		items = append(items, list.Items...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (g *googleBackend) Busy(calendarIDs []string, from, to time.Time) ([]*calendar.TimePeriod, error) {
+	req := &calendar.FreeBusyRequest{TimeMin: from.Format(time.RFC3339), TimeMax: to.Format(time.RFC3339)}
+	for _, id := range calendarIDs {
+		req.Items = append(req.Items, &calendar.FreeBusyRequestItem{Id: id})
+	}
+	resp, err := g.srv.Freebusy.Query(req).Do()
+	if err != nil {
+		return nil, err
+	}
+	var busy []*calendar.TimePeriod
+	for id, cal := range resp.Calendars {
+		if len(cal.Errors) > 0 {
+			return nil, fmt.Errorf("free/busy of calendar %v: %v", id, cal.Errors[0].Reason)
+		}
+		busy = append(busy, cal.Busy...)
+	}
+	return busy, nil
+}
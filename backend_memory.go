@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// memoryTimeZone is the time zone of all calendars of the memory backend.
+const memoryTimeZone = "Europe/Berlin"
+
+// memoryCalendars are the calendars of the memory backend. Events can be
+// added to any calendar ID, these are the ones listed.
+var memoryCalendars = []*calendar.CalendarListEntry{
+	{Id: "primary", Summary: "Kalender", Primary: true, AccessRole: "owner", TimeZone: memoryTimeZone},
+	{Id: "team", Summary: "Team", AccessRole: "writer", TimeZone: memoryTimeZone},
+}
+
+// memoryMaxScan is the most events Busy and Search look at per calendar.
+const memoryMaxScan = 1000
+
+// memoryHorizon is how far the memory backend looks ahead for events of a series.
+const memoryHorizon = 3 * 365 * 24 * time.Hour
+
+// memoryBackend is a CalendarBackend keeping all events in memory.
+// It is meant for running the bot offline and for exercising the handlers.
+// Like the Calendar API it expands series into single events, which have
+// the ID of the series and their start time as ID until they are changed.
+type memoryBackend struct {
+	mu     sync.Mutex
+	events map[string]map[string]*calendar.Event // calendar ID -> event ID -> event
+	nextID int
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{events: make(map[string]map[string]*calendar.Event)}
+}
+
+func (mb *memoryBackend) Upcoming(calendarID string, from time.Time, max int64) ([]*calendar.Event, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.list(calendarID, from, time.Time{}, max), nil
+}
+
+// list returns at most max single events that end after from and,
+// unless to is zero, start before to.
+func (mb *memoryBackend) list(calendarID string, from, to time.Time, max int64) []*calendar.Event {
+	var items []*calendar.Event
+	for _, evt := range mb.events[calendarID] {
+		switch {
+		case evt.RecurringEventId != "":
+			//changed events of a series are returned by expand
+		case len(evt.Recurrence) > 0:
+			items = append(items, mb.expand(calendarID, evt, from, to, max)...)
+		case eventTime(evt.End).After(from) && (to.IsZero() || eventTime(evt.Start).Before(to)):
+			items = append(items, copyEvent(evt))
+		}
+	}
+	return sortEvents(items, max)
+}
+
+func (mb *memoryBackend) Between(calendarID string, from, to time.Time, max int64) ([]*calendar.Event, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.list(calendarID, from, to, max), nil
+}
+
+func (mb *memoryBackend) Search(calendarID, query string, from, to time.Time, max int64) ([]*calendar.Event, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	var items []*calendar.Event
+	for _, evt := range mb.list(calendarID, from, to, memoryMaxScan) {
+		if matchesQuery(evt, query) {
+			items = append(items, evt)
+		}
+	}
+	return sortEvents(items, max), nil
+}
+
+func (mb *memoryBackend) Instances(calendarID, eventID string, from time.Time, max int64) ([]*calendar.Event, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	stored, ok := mb.events[calendarID][eventID]
+	if !ok {
+		return nil, errEventNotFound(calendarID, eventID)
+	}
+	if len(stored.Recurrence) == 0 {
+		if eventTime(stored.End).After(from) {
+			return []*calendar.Event{copyEvent(stored)}, nil
+		}
+		return nil, nil
+	}
+	return sortEvents(mb.expand(calendarID, stored, from, time.Time{}, max), max), nil
+}
+
+// expand returns at most max events of a series that end after from and,
+// unless to is zero, start before to. Changed events of the series replace
+// the ones they were created from.
+func (mb *memoryBackend) expand(calendarID string, series *calendar.Event, from, to time.Time, max int64) []*calendar.Event {
+	rule, ok := parseRRule(series.Recurrence)
+	if !ok {
+		return nil
+	}
+	allDay := series.Start.DateTime == ""
+	loc := time.UTC
+	if !allDay {
+		loc = eventLocation(series.Start)
+	}
+	start := eventTime(series.Start).In(loc)
+	length := eventTime(series.End).Sub(eventTime(series.Start))
+	first := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+
+	var items []*calendar.Event
+	n := 0
+	for day := first; day.Sub(first) < memoryHorizon && int64(len(items)) < max; day = day.AddDate(0, 0, 1) {
+		if !rule.matches(first, day) {
+			continue
+		}
+		n++
+		occurrence := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), start.Second(), 0, loc)
+		if (rule.count > 0 && n > rule.count) || (!rule.until.IsZero() && occurrence.After(rule.until)) ||
+			(!to.IsZero() && !occurrence.Before(to)) {
+			break
+		}
+		evt, ok := mb.events[calendarID][instanceID(series.Id, occurrence, allDay)]
+		if !ok {
+			evt = newInstance(series, occurrence, length, allDay)
+		}
+		if evt.Status != "cancelled" && eventTime(evt.End).After(from) && (to.IsZero() || eventTime(evt.Start).Before(to)) {
+			items = append(items, copyEvent(evt))
+		}
+	}
+	return items
+}
+
+// instance returns the unchanged event of a series with the given ID,
+// which has the ID of the series and its start time.
+func (mb *memoryBackend) instance(calendarID, eventID string) (*calendar.Event, bool) {
+	i := strings.LastIndex(eventID, "_")
+	if i < 0 {
+		return nil, false
+	}
+	series, ok := mb.events[calendarID][eventID[:i]]
+	if !ok || len(series.Recurrence) == 0 {
+		return nil, false
+	}
+	allDay := series.Start.DateTime == ""
+	var occurrence time.Time
+	var err error
+	if allDay {
+		occurrence, err = time.Parse("20060102", eventID[i+1:])
+	} else {
+		occurrence, err = time.Parse("20060102T150405Z", eventID[i+1:])
+		occurrence = occurrence.In(eventLocation(series.Start))
+	}
+	if err != nil {
+		return nil, false
+	}
+	length := eventTime(series.End).Sub(eventTime(series.Start))
+	return newInstance(series, occurrence, length, allDay), true
+}
+
+func (mb *memoryBackend) Get(calendarID, eventID string) (*calendar.Event, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	stored, ok := mb.events[calendarID][eventID]
+	if !ok {
+		stored, ok = mb.instance(calendarID, eventID)
+	}
+	if !ok {
+		return nil, errEventNotFound(calendarID, eventID)
+	}
+	return copyEvent(stored), nil
+}
+
+// Insert, Delete and Patch do not send emails, so sendUpdates is ignored.
+func (mb *memoryBackend) Insert(calendarID string, evt *calendar.Event, sendUpdates string) (*calendar.Event, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.nextID++
+	stored := copyEvent(evt)
+	stored.Id = "mem" + strconv.Itoa(mb.nextID)
+	stored.Etag = "1"
+	stored.Status = "confirmed"
+	if mb.events[calendarID] == nil {
+		mb.events[calendarID] = make(map[string]*calendar.Event)
+	}
+	mb.events[calendarID][stored.Id] = stored
+	return copyEvent(stored), nil
+}
+
+func (mb *memoryBackend) Delete(calendarID, eventID, sendUpdates string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	stored, ok := mb.events[calendarID][eventID]
+	if !ok {
+		stored, ok = mb.instance(calendarID, eventID)
+	}
+	if !ok {
+		return errEventNotFound(calendarID, eventID)
+	}
+
+	//deleted events of a series are kept as cancelled, so the series skips them
+	if stored.RecurringEventId != "" {
+		stored.Status = "cancelled"
+		mb.events[calendarID][eventID] = stored
+		return nil
+	}
+	for id, evt := range mb.events[calendarID] {
+		if evt.RecurringEventId == eventID {
+			delete(mb.events[calendarID], id)
+		}
+	}
+	delete(mb.events[calendarID], eventID)
+	return nil
+}
+
+func (mb *memoryBackend) Patch(calendarID, eventID, etag string, evt *calendar.Event, sendUpdates string) (*calendar.Event, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	stored, ok := mb.events[calendarID][eventID]
+	if !ok {
+		//changing an event of a series detaches it from the series
+		stored, ok = mb.instance(calendarID, eventID)
+		if ok {
+			mb.events[calendarID][eventID] = stored
+		}
+	}
+	if !ok {
+		return nil, errEventNotFound(calendarID, eventID)
+	}
+	if etag != "" && etag != stored.Etag {
+		return nil, &googleapi.Error{Code: http.StatusPreconditionFailed, Message: "event " + eventID + " has been changed"}
+	}
+	if evt.Summary != "" {
+		stored.Summary = evt.Summary
+	}
+	if evt.Description != "" {
+		stored.Description = evt.Description
+	}
+	if evt.Location != "" {
+		stored.Location = evt.Location
+	}
+	if evt.Start != nil {
+		stored.Start = evt.Start
+	}
+	if evt.End != nil {
+		stored.End = evt.End
+	}
+	if evt.Recurrence != nil {
+		stored.Recurrence = evt.Recurrence
+	}
+	if evt.Attendees != nil || containsString(evt.NullFields, "Attendees") {
+		stored.Attendees = evt.Attendees
+	}
+	version, _ := strconv.Atoi(stored.Etag)
+	stored.Etag = strconv.Itoa(version + 1)
+	return copyEvent(stored), nil
+}
+
+func (mb *memoryBackend) TimeZone(calendarID string) (string, error) {
+	return memoryTimeZone, nil
+}
+
+func (mb *memoryBackend) Calendars() ([]*calendar.CalendarListEntry, error) {
+	items := make([]*calendar.CalendarListEntry, len(memoryCalendars))
+	for i, entry := range memoryCalendars {
+		c := *entry
+		items[i] = &c
+	}
+	return items, nil
+}
+
+// Busy treats all timed events as busy, like the Calendar API does
+// unless an event is marked as free.
+func (mb *memoryBackend) Busy(calendarIDs []string, from, to time.Time) ([]*calendar.TimePeriod, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	var busy []*calendar.TimePeriod
+	for _, id := range calendarIDs {
+		for _, evt := range mb.list(id, from, to, memoryMaxScan) {
+			if evt.Start.DateTime == "" || evt.Transparency == "transparent" {
+				continue
+			}
+			busy = append(busy, &calendar.TimePeriod{Start: evt.Start.DateTime, End: evt.End.DateTime})
+		}
+	}
+	return busy, nil
+}
+
+// errEventNotFound mimics the error of the Calendar API for missing events,
+// so callers handle both backends alike.
+func errEventNotFound(calendarID, eventID string) error {
+	return &googleapi.Error{
+		Code:    http.StatusNotFound,
+		Message: fmt.Sprintf("event %v not found in calendar %v", eventID, calendarID),
+	}
+}
+
+// newInstance returns the event of a series starting at occurrence.
+func newInstance(series *calendar.Event, occurrence time.Time, length time.Duration, allDay bool) *calendar.Event {
+	evt := copyEvent(series)
+	evt.Id = instanceID(series.Id, occurrence, allDay)
+	evt.RecurringEventId = series.Id
+	evt.Recurrence = nil
+	if allDay {
+		evt.Start = &calendar.EventDateTime{Date: occurrence.Format("2006-01-02")}
+		evt.End = &calendar.EventDateTime{Date: occurrence.Add(length).Format("2006-01-02")}
+	} else {
+		evt.Start = &calendar.EventDateTime{DateTime: occurrence.Format(time.RFC3339), TimeZone: series.Start.TimeZone}
+		evt.End = &calendar.EventDateTime{DateTime: occurrence.Add(length).Format(time.RFC3339), TimeZone: series.End.TimeZone}
+	}
+	evt.OriginalStartTime = evt.Start
+	return evt
+}
+
+// instanceID returns the ID of the event of a series starting at occurrence,
+// built like the IDs of the Calendar API.
+func instanceID(seriesID string, occurrence time.Time, allDay bool) string {
+	if allDay {
+		return seriesID + "_" + occurrence.Format("20060102")
+	}
+	return seriesID + "_" + occurrence.UTC().Format("20060102T150405Z")
+}
+
+// eventLocation returns the time zone of a timed event start or end.
+func eventLocation(dt *calendar.EventDateTime) *time.Location {
+	if loc, err := time.LoadLocation(dt.TimeZone); err == nil && dt.TimeZone != "" {
+		return loc
+	}
+	loc, _ := time.LoadLocation(memoryTimeZone)
+	return loc
+}
+
+// sortEvents orders events by their start time and keeps at most max of them.
+func sortEvents(items []*calendar.Event, max int64) []*calendar.Event {
+	sort.Slice(items, func(i, j int) bool {
+		return eventTime(items[i].Start).Before(eventTime(items[j].Start))
+	})
+	if int64(len(items)) > max {
+		items = items[:max]
+	}
+	return items
+}
+
+// copyEvent returns a shallow copy of evt, so callers cannot modify
+// the stored events behind the backend's back.
+func copyEvent(evt *calendar.Event) *calendar.Event {
+	c := *evt
+	return &c
+}
+
+func containsString(values []string, v string) bool {
+	for _, other := range values {
+		if other == v {
+			return true
+		}
+	}
+	return false
+}
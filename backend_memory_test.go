@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestMemoryBackendSeries(t *testing.T) {
+	mb := newMemoryBackend()
+	loc := testLocation(t)
+	start := time.Date(2025, 3, 17, 10, 0, 0, 0, loc)
+	series, err := mb.Insert("primary", &calendar.Event{
+		Summary:    "Standup",
+		Start:      newDateTime(start, false),
+		End:        newDateTime(start.Add(time.Hour), false),
+		Recurrence: []string{"RRULE:FREQ=WEEKLY;BYDAY=MO,TH;COUNT=4"},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := mb.Instances("primary", series.Id, start, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var starts []string
+	for _, evt := range events {
+		starts = append(starts, eventTime(evt.Start).In(loc).Format(testLayout))
+	}
+	want := "[2025-03-17 10:00 2025-03-20 10:00 2025-03-24 10:00 2025-03-27 10:00]"
+	if got := fmt.Sprint(starts); got != want {
+		t.Fatalf("instances start at %v, want %v", got, want)
+	}
+
+	//changing one event detaches it, the series lists the changed event
+	moved, err := mb.Patch("primary", events[1].Id, "", &calendar.Event{Summary: "Standup (verschoben)"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	between, err := mb.Between("primary", start, start.AddDate(0, 0, 7), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(between) != 2 || between[1].Id != moved.Id || between[1].Summary != "Standup (verschoben)" {
+		t.Errorf("Between lists %+v after the change", between)
+	}
+
+	if err := mb.Delete("primary", series.Id, ""); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := mb.Between("primary", start, start.AddDate(0, 1, 0), 10); len(left) != 0 {
+		t.Errorf("%v events left after deleting the series", len(left))
+	}
+}
+
+func TestMemoryBackendEtag(t *testing.T) {
+	mb := newMemoryBackend()
+	start := time.Now().Add(time.Hour).Truncate(time.Hour)
+	evt, err := mb.Insert("primary", &calendar.Event{
+		Summary: "Review",
+		Start:   newDateTime(start, false),
+		End:     newDateTime(start.Add(time.Hour), false),
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := mb.Patch("primary", evt.Id, evt.Etag, &calendar.Event{Location: "Raum 2"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Etag == evt.Etag || changed.Summary != "Review" || changed.Location != "Raum 2" {
+		t.Errorf("Patch returned %+v", changed)
+	}
+	//a change based on the old version is a conflict
+	_, err = mb.Patch("primary", evt.Id, evt.Etag, &calendar.Event{Summary: "Retro"}, "")
+	if kind := classify(err).kind; kind != kindConflict {
+		t.Errorf("Patch with an old etag: %v, want a conflict", err)
+	}
+	if _, err := mb.Get("primary", "missing"); classify(err).kind != kindNotFound {
+		t.Errorf("Get of a missing event: %v, want not found", err)
+	}
+}
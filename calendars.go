@@ -0,0 +1,151 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/yanzay/tbot"
+	"google.golang.org/api/calendar/v3"
+)
+
+// calendarModifier matches the in:<calendar> modifier of /add and /show,
+// names with spaces are quoted like in:"Team Kalender".
+var calendarModifier = regexp.MustCompile(`(?i)(^|\s)in:("[^"]*"|\S+)`)
+
+// takeCalendar removes an in:<calendar> modifier from text and returns the
+// rest of the text and the name of the calendar, empty without modifier.
+func takeCalendar(text string) (string, string) {
+	match := calendarModifier.FindStringSubmatchIndex(text)
+	if match == nil {
+		return text, ""
+	}
+	name := strings.Trim(text[match[4]:match[5]], `"`)
+	rest := strings.TrimSpace(strings.TrimSpace(text[:match[0]]) + " " + strings.TrimSpace(text[match[1]:]))
+	return rest, name
+}
+
+// calendarName returns the name the user sees a calendar under.
+func calendarName(entry *calendar.CalendarListEntry) string {
+	switch {
+	case entry.SummaryOverride != "":
+		return entry.SummaryOverride
+	case entry.Summary != "":
+		return entry.Summary
+	}
+	return entry.Id
+}
+
+// normalizeCalendarName makes names comparable regardless of case and spaces.
+func normalizeCalendarName(name string) string {
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(name))
+}
+
+// findCalendar returns the calendar of the user's list with the given ID or
+// name, or else the only one whose name starts with name.
+func findCalendar(backend CalendarBackend, name string) (*calendar.CalendarListEntry, error) {
+	entries, err := backend.Calendars()
+	if err != nil {
+		return nil, err
+	}
+	wanted := normalizeCalendarName(name)
+	var candidates []*calendar.CalendarListEntry
+	for _, entry := range entries {
+		if entry.Id == name || normalizeCalendarName(calendarName(entry)) == wanted {
+			return entry, nil
+		}
+		if strings.HasPrefix(normalizeCalendarName(calendarName(entry)), wanted) {
+			candidates = append(candidates, entry)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	return nil, notFoundError("Den Kalender %v kenne ich nicht, deine Kalender zeigt /calendars.", name)
+}
+
+// targetCalendar returns the ID and name of the calendar a command in a
+// chat works on: the one named by an in:<calendar> modifier, or else
+// defaultID. Without modifier the name is empty, as replies do not need
+// to mention it. A chat bound to a calendar may only name that one, the
+// other calendars of whoever bound it are none of the group's business.
+func targetCalendar(chatID int64, backend CalendarBackend, defaultID, name string) (string, string, error) {
+	if name == "" {
+		return defaultID, "", nil
+	}
+	g, bound, err := loadGroup(chatID)
+	if err != nil {
+		return "", "", err
+	}
+	entry, err := findCalendar(backend, name)
+	if bound && (err != nil || entry.Id != g.CalendarID) {
+		return "", "", &botError{kind: kindDenied, msg: "Diese Gruppe kann nur den Kalender nutzen, an den sie gebunden ist."}
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return entry.Id, calendarName(entry), nil
+}
+
+// isReadOnly reports whether events cannot be added to a calendar.
+func isReadOnly(entry *calendar.CalendarListEntry) bool {
+	return entry.AccessRole == "reader" || entry.AccessRole == "freeBusyReader"
+}
+
+// CalendarsHandler lists the calendars of the user for /calendars
+// and marks the one /add and /show use.
+func CalendarsHandler(message *tbot.Message) error {
+	backend, err := backendFor(message.From.ID)
+	if err != nil {
+		return err
+	}
+	u, err := loadUser(message.From.ID)
+	if err != nil {
+		return err
+	}
+	entries, err := backend.Calendars()
+	if err != nil {
+		return err
+	}
+
+	lines := []string{"Deine Kalender:"}
+	for _, entry := range entries {
+		line := "- " + calendarName(entry)
+		if entry.Id == u.calendar() || (entry.Primary && u.calendar() == "primary") {
+			line = "✓ " + calendarName(entry) + " (Standard)"
+		}
+		if isReadOnly(entry) {
+			line += " (nur lesen)"
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", "Mit /use <Name> wählst du den Kalender für /add und /show, "+
+		"mit in:<Name> z.B. /add Planung morgen 10-11 in:Team nur für einen Befehl.")
+	message.Reply(strings.Join(lines, "\n"))
+	return nil
+}
+
+// UseHandler makes a calendar the default of the user for /use {name}.
+func UseHandler(message *tbot.Message) error {
+	name := strings.Trim(strings.TrimSpace(message.Vars["name"]), `"`)
+	if name == "" {
+		return parseError("Bitte gib den Kalender an, z.B. /use Team. Deine Kalender zeigt /calendars.")
+	}
+	backend, err := backendFor(message.From.ID)
+	if err != nil {
+		return err
+	}
+	entry, err := findCalendar(backend, name)
+	if err != nil {
+		return err
+	}
+
+	if err := updateUser(message.From.ID, func(u *user) { u.Calendar = entry.Id }); err != nil {
+		return err
+	}
+	if isReadOnly(entry) {
+		message.Replyf("/show zeigt jetzt die Termine aus %v. Neue Termine kannst du dort nicht anlegen, da du den Kalender nur lesen darfst.", calendarName(entry))
+		return nil
+	}
+	message.Replyf("/add und /show verwenden jetzt den Kalender %v.", calendarName(entry))
+	return nil
+}
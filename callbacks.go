@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/yanzay/tbot"
+	"github.com/yanzay/tbot/model"
+	"google.golang.org/api/calendar/v3"
+)
+
+// eventButtons are the inline buttons attached to each event listed by /show.
+// Their callback data is the action and the handle of the event.
+// Every button gets its own row, so the order of the buttons is stable.
+func eventButtons(handle string) []map[string]string {
+	return []map[string]string{
+		{"Löschen": "del:" + handle},
+		{"1 Stunde später": "later:" + handle},
+		{"Auf morgen verschieben": "tmrw:" + handle},
+		{"Details": "info:" + handle},
+	}
+}
+
+// isCallback reports whether message is a press on an inline button.
+func isCallback(message *tbot.Message) bool {
+	return message.Type == model.MessageInlineKeyboard && message.CallbackQuery.ID != ""
+}
+
+// sender returns the Telegram user who sent a message or pressed a button.
+// For button presses message.From is the bot, which sent the message with the buttons.
+func sender(message *tbot.Message) model.User {
+	if isCallback(message) {
+		return message.CallbackQuery.From
+	}
+	return message.From
+}
+
+// senderID returns the ID of the Telegram user who sent a message or pressed a button.
+func senderID(message *tbot.Message) int {
+	return sender(message).ID
+}
+
+// CallbackHandler handles presses on the inline buttons of listed events.
+// tbot routes them by the text of the message carrying the buttons,
+// so it is registered as default handler and ignores everything else.
+func CallbackHandler(message *tbot.Message) error {
+	if !isCallback(message) {
+		return nil
+	}
+	defer answerCallback(message)
+
+	parts := strings.SplitN(message.CallbackQuery.Data, ":", 2)
+	if len(parts) != 2 {
+		return parseError("Mit diesem Knopf kann ich nichts anfangen.")
+	}
+	action, handle := parts[0], parts[1]
+	switch action {
+	case "snooze":
+		return snoozeReminder(message, handle)
+	case "slot":
+		return bookSlot(message, handle)
+	}
+
+	ref, err := resolveRef(message.ChatID, handle)
+	if err != nil {
+		return err
+	}
+	backend, calendarID, err := chatBackend(message.ChatID, senderID(message))
+	if err != nil {
+		return err
+	}
+	evt, err := currentEvent(backend, ref)
+	if err != nil {
+		return err
+	}
+	loc, err := userLocation(senderID(message), backend, calendarID)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "del":
+		message.ReplyInlineKeyboard(fmt.Sprintf("Soll ich den Termin %v (%v) wirklich löschen?", evt.Summary, formatEventTime(evt, loc)),
+			[]map[string]string{{"Ja, löschen": "delok:" + handle}}, tbot.WithDataInlineButtons)
+	case "delok":
+		if err := backend.Delete(ref.CalendarID, ref.EventID, guestUpdates(evt)); err != nil {
+			return err
+		}
+		message.Replyf("Termin %v gelöscht%v", evt.Summary, byMember(message))
+	case "later":
+		if evt.Start.DateTime == "" {
+			return parseError("Ganztägige Termine kann ich nicht um eine Stunde verschieben.")
+		}
+		return moveEvent(message, backend, ref, evt, loc, 0, time.Hour)
+	case "tmrw":
+		start := eventTime(evt.Start)
+		if evt.Start.DateTime != "" {
+			start = start.In(loc)
+		}
+		return moveEvent(message, backend, ref, evt, loc, daysBetween(start, time.Now().In(loc).AddDate(0, 0, 1)), 0)
+	case "info":
+		message.Reply(formatEventDetails(evt, loc), tbot.DisablePreview)
+	default:
+		return parseError("Mit diesem Knopf kann ich nichts anfangen.")
+	}
+	return nil
+}
+
+// answerCallback confirms a button press to Telegram, which otherwise
+// keeps showing a progress indicator on the button.
+func answerCallback(message *tbot.Message) {
+	err := bot.SendRaw("answerCallbackQuery", map[string]string{"callback_query_id": message.CallbackQuery.ID})
+	if err != nil {
+		log.Printf("Error answering callback query: %v", err)
+	}
+}
+
+// moveEvent moves an event by days and d, keeping its duration,
+// and updates the handle to the new version of the event.
+func moveEvent(message *tbot.Message, backend CalendarBackend, ref eventRef, evt *calendar.Event, loc *time.Location, days int, d time.Duration) error {
+	patch := &calendar.Event{
+		Start: shiftDateTime(evt.Start, days, d, loc),
+		End:   shiftDateTime(evt.End, days, d, loc),
+	}
+	moved, err := backend.Patch(ref.CalendarID, ref.EventID, evt.Etag, patch, guestUpdates(evt))
+	if err != nil {
+		return err
+	}
+	if _, err := issueRefs(message.ChatID, ref.CalendarID, []*calendar.Event{moved}); err != nil {
+		return err
+	}
+	message.Replyf("Termin %v verschoben auf %v%v", moved.Summary, formatEventTime(moved, loc), byMember(message))
+	return nil
+}
+
+// shiftDateTime moves an event start or end by days and d. Timed values
+// are moved in their own time zone, so they keep their wall clock time
+// across daylight saving changes. All-day dates only move by days.
+func shiftDateTime(dt *calendar.EventDateTime, days int, d time.Duration, loc *time.Location) *calendar.EventDateTime {
+	if dt.DateTime == "" {
+		return &calendar.EventDateTime{Date: eventTime(dt).AddDate(0, 0, days).Format("2006-01-02")}
+	}
+	if dt.TimeZone != "" {
+		if eventLoc, err := time.LoadLocation(dt.TimeZone); err == nil {
+			loc = eventLoc
+		}
+	}
+	t := eventTime(dt).In(loc).AddDate(0, 0, days).Add(d)
+	return &calendar.EventDateTime{DateTime: t.Format(time.RFC3339), TimeZone: dt.TimeZone}
+}
+
+// daysBetween returns the number of calendar days from the day of a to the day of b.
+func daysBetween(a, b time.Time) int {
+	ad := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	bd := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(bd.Sub(ad).Hours() / 24)
+}
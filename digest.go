@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clndr/parser"
+	"github.com/yanzay/tbot"
+)
+
+const (
+	digestsBucket = "digests"
+
+	// digestInterval is how often the scheduler looks for due digests.
+	digestInterval = time.Minute
+	// digestGrace is how late a digest is still sent, e.g. after the bot was down.
+	digestGrace = 6 * time.Hour
+	// digestMax is the most events a digest lists.
+	digestMax = 100
+)
+
+// digest is a subscription of a chat to a daily or weekly agenda.
+// The agenda is read from the calendar of the user who subscribed,
+// in the time zone they subscribed in.
+type digest struct {
+	ChatID   int64        `json:"chat_id"`
+	UserID   int          `json:"user_id"`
+	Weekly   bool         `json:"weekly,omitempty"`
+	Weekday  time.Weekday `json:"weekday,omitempty"` // day of weekly digests
+	Hour     int          `json:"hour"`
+	Minute   int          `json:"minute"`
+	TimeZone string       `json:"time_zone,omitempty"` // IANA name, empty for digests subscribed before zones were kept
+	Last     time.Time    `json:"last"`                // when the digest was sent last
+}
+
+func (d *digest) key() string {
+	if d.Weekly {
+		return strconv.FormatInt(d.ChatID, 10) + "/weekly"
+	}
+	return strconv.FormatInt(d.ChatID, 10) + "/daily"
+}
+
+// scheduled returns the latest time at or before now the digest is due.
+func (d *digest) scheduled(now time.Time) time.Time {
+	t := time.Date(now.Year(), now.Month(), now.Day(), d.Hour, d.Minute, 0, 0, now.Location())
+	if t.After(now) {
+		t = t.AddDate(0, 0, -1)
+	}
+	if d.Weekly {
+		t = t.AddDate(0, 0, -((int(t.Weekday()) - int(d.Weekday) + 7) % 7))
+	}
+	return t
+}
+
+// period returns the days the digest sent at t covers. Digests sent
+// before noon cover the day or week starting that day, later ones the
+// day or week starting the next day.
+func (d *digest) period(t time.Time) (from, to time.Time) {
+	from = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if t.Hour() >= 12 {
+		from = from.AddDate(0, 0, 1)
+	}
+	if d.Weekly {
+		return from, from.AddDate(0, 0, 7)
+	}
+	return from, from.AddDate(0, 0, 1)
+}
+
+// describe tells in German when the digest is sent.
+func (d *digest) describe() string {
+	at := fmt.Sprintf("%02d:%02d", d.Hour, d.Minute)
+	if d.Weekly {
+		return "wöchentlich am " + weekdayNames[d.Weekday] + " um " + at
+	}
+	return "täglich um " + at
+}
+
+// location returns the time zone of a digest. Digests without one get
+// the zone of the user who subscribed, which is kept from then on.
+func (d *digest) location() (*time.Location, error) {
+	if d.TimeZone != "" {
+		return time.LoadLocation(d.TimeZone)
+	}
+	backend, calendarID, err := chatBackend(d.ChatID, d.UserID)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := userLocation(d.UserID, backend, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	d.TimeZone = loc.String()
+	return loc, store.Put(digestsBucket, d.key(), d)
+}
+
+// chatDigests returns the digests a chat subscribed to.
+func chatDigests(chatID int64) ([]*digest, error) {
+	var digests []*digest
+	for _, weekly := range []bool{false, true} {
+		d := &digest{ChatID: chatID, Weekly: weekly}
+		ok, err := store.Get(digestsBucket, d.key(), d)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			digests = append(digests, d)
+		}
+	}
+	return digests, nil
+}
+
+const digestUsage = "So bekommst du die Termine automatisch:\n" +
+	"/digest daily 07:30 - jeden Tag die Termine des Tages\n" +
+	"/digest weekly sun 18:00 - jede Woche die Termine der nächsten sieben Tage\n" +
+	"/digest daily aus - eine Zusammenfassung abbestellen, /digest aus - alle abbestellen"
+
+// DigestHandler subscribes a chat to a daily or weekly agenda with
+// "/digest daily 07:30" or "/digest weekly sun 18:00", unsubscribes it
+// with "/digest daily aus" or "/digest aus" and lists the subscriptions
+// for "/digest".
+func DigestHandler(message *tbot.Message) error {
+	args := strings.Fields(message.Vars["args"])
+	if len(args) == 0 {
+		return listDigests(message)
+	}
+
+	var weekly bool
+	switch strings.ToLower(args[0]) {
+	case "daily", "täglich":
+	case "weekly", "wöchentlich":
+		weekly = true
+	case "aus", "off", "stop":
+		return stopDigests(message, false, true)
+	default:
+		return parseError(digestUsage)
+	}
+	if len(args) == 2 && isOff(args[1]) {
+		return stopDigests(message, weekly, false)
+	}
+	if len(args) < 2 {
+		return parseError(digestUsage)
+	}
+
+	backend, calendarID, err := chatBackend(message.ChatID, message.From.ID)
+	if err != nil {
+		return err
+	}
+	loc, err := userLocation(message.From.ID, backend, calendarID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().In(loc)
+	//parsed from midnight, so a time that passed today stays on today
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	parsed, err := parser.ParseTime(strings.Join(args[1:], " "), today)
+	if err != nil || parsed.AllDay || parsed.Name != "" {
+		return parseError(digestUsage)
+	}
+	//weekdays are always parsed as a day after today
+	if weekly && sameDay(parsed.Start, now) {
+		return parseError("Bitte gib den Wochentag an, z.B. /digest weekly sun 18:00.")
+	}
+
+	d := &digest{
+		ChatID:   message.ChatID,
+		UserID:   message.From.ID,
+		Weekly:   weekly,
+		Weekday:  parsed.Start.Weekday(),
+		Hour:     parsed.Start.Hour(),
+		Minute:   parsed.Start.Minute(),
+		TimeZone: loc.String(),
+		Last:     now,
+	}
+	if err := store.Put(digestsBucket, d.key(), d); err != nil {
+		return err
+	}
+	message.Replyf("Ich schicke diesem Chat die Termine %v (%v).", d.describe(), loc)
+	return nil
+}
+
+func listDigests(message *tbot.Message) error {
+	digests, err := chatDigests(message.ChatID)
+	if err != nil {
+		return err
+	}
+	if len(digests) == 0 {
+		message.Reply("Dieser Chat bekommt keine Zusammenfassungen.\n\n" + digestUsage)
+		return nil
+	}
+	var lines []string
+	for _, d := range digests {
+		lines = append(lines, "- "+d.describe())
+	}
+	message.Reply("Dieser Chat bekommt die Termine\n" + strings.Join(lines, "\n"))
+	return nil
+}
+
+func stopDigests(message *tbot.Message, weekly, all bool) error {
+	digests, err := chatDigests(message.ChatID)
+	if err != nil {
+		return err
+	}
+	for _, d := range digests {
+		if all || d.Weekly == weekly {
+			if err := store.Delete(digestsBucket, d.key()); err != nil {
+				return err
+			}
+		}
+	}
+	message.Reply("Die Zusammenfassung ist abbestellt.")
+	return nil
+}
+
+// isOff reports whether word switches something off.
+func isOff(word string) bool {
+	switch strings.ToLower(word) {
+	case "aus", "off", "stop", "nein":
+		return true
+	}
+	return false
+}
+
+// startDigests runs the digest scheduler in the background.
+func startDigests() {
+	go func() {
+		for now := range time.Tick(digestInterval) {
+			checkDigests(now)
+		}
+	}()
+}
+
+// checkDigests sends all digests due at now.
+func checkDigests(now time.Time) {
+	keys, err := store.Keys(digestsBucket)
+	if err != nil {
+		log.Printf("Error loading digests: %v", err)
+		return
+	}
+	for _, key := range keys {
+		d := &digest{}
+		if _, err := store.Get(digestsBucket, key, d); err != nil {
+			log.Printf("Error loading digest %v: %v", key, err)
+			continue
+		}
+		if err := sendDigest(d, now); err != nil {
+			log.Printf("Error sending digest %v: %v", key, err)
+		}
+	}
+}
+
+// sendDigest sends the agenda of a digest if it is due. The calendar
+// is only asked for digests that are due.
+func sendDigest(d *digest, now time.Time) error {
+	loc, err := d.location()
+	if err != nil {
+		return err
+	}
+	at := d.scheduled(now.In(loc))
+	if !d.Last.Before(at) || now.Sub(at) > digestGrace {
+		return nil
+	}
+
+	//remember the digest as sent first, a failing calendar must not cause a flood
+	d.Last = now
+	if err := store.Put(digestsBucket, d.key(), d); err != nil {
+		return err
+	}
+
+	backend, calendarID, err := chatBackend(d.ChatID, d.UserID)
+	if err != nil {
+		return err
+	}
+
+	from, to := d.period(at)
+	events, err := backend.Between(calendarID, from, to, digestMax)
+	if err != nil {
+		return err
+	}
+	title := "Deine Termine heute"
+	switch {
+	case d.Weekly:
+		title = "Deine Termine vom " + from.Format(dateFormat) + " bis " + to.AddDate(0, 0, -1).Format(dateFormat)
+	case !sameDay(from, at):
+		title = "Deine Termine morgen"
+	}
+	if len(events) == 0 {
+		return bot.Send(d.ChatID, title+": keine.")
+	}
+	handles, err := issueRefs(d.ChatID, calendarID, events)
+	if err != nil {
+		return err
+	}
+	return bot.Send(d.ChatID, title+":\n\n"+formatAgenda(events, handles, from, loc))
+}
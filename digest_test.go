@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendDigestNotDue(t *testing.T) {
+	store = newMemoryStore()
+	t.Cleanup(func() { store = nil })
+
+	loc := testLocation(t)
+	now := time.Date(2025, 3, 12, 11, 17, 0, 0, loc)
+	d := &digest{ChatID: -100, UserID: testUser, Hour: 7, Minute: 30, TimeZone: loc.String(), Last: now.Add(-3 * time.Hour)}
+	//the user has no calendar, a digest that is not due must not need one
+	if err := sendDigest(d, now); err != nil {
+		t.Errorf("sendDigest of a digest sent at 07:30: %v", err)
+	}
+	if !d.Last.Equal(now.Add(-3 * time.Hour)) {
+		t.Errorf("sendDigest marked a digest sent at 07:30 as sent again at %v", d.Last)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/clndr/parser"
+	"github.com/yanzay/tbot"
+	"google.golang.org/api/calendar/v3"
+)
+
+// editFields maps the keys of /edit changes to the event field they change.
+var editFields = map[string]string{
+	"name": "name", "titel": "name", "title": "name",
+	"zeit": "time", "wann": "time", "time": "time", "when": "time",
+	"dauer": "duration", "duration": "duration",
+	"ort": "location", "wo": "location", "location": "location", "where": "location",
+	"beschreibung": "description", "notiz": "description", "description": "description",
+}
+
+// editKeyExpr finds the keys of /edit changes like "name:" or "ort:"
+var editKeyExpr = regexp.MustCompile(`(?i)(?:^|[\s;])(name|titel|title|zeit|wann|time|when|dauer|duration|ort|wo|location|where|beschreibung|notiz|description):`)
+
+const editUsage = "Bitte gib den Termin und die Änderungen an, z.B. " +
+	"/edit k3f9 name: Sprint Review; zeit: morgen 10-11; dauer: 90min; ort: Raum 2; beschreibung: Agenda im Wiki\n" +
+	"Um alle Termine einer Serie zu ändern, sende /edit k3f9 serie name: Sprint Review"
+
+// parseChanges splits the changes of /edit into fields and their new values.
+func parseChanges(text string) (map[string]string, error) {
+	matches := editKeyExpr.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 || strings.TrimSpace(text[:matches[0][0]]) != "" {
+		return nil, parseError(editUsage)
+	}
+	changes := make(map[string]string)
+	for i, m := range matches {
+		end := len(text)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		value := strings.Trim(text[m[1]:end], " ;")
+		changes[editFields[strings.ToLower(text[m[2]:m[3]])]] = value
+	}
+	return changes, nil
+}
+
+// EditHandler changes name, time, duration, location or description
+// of the event behind a handle. The change is refused if the event was
+// modified elsewhere since it was listed. Of a series only the one event
+// is changed, unless the changes start with "serie".
+func EditHandler(message *tbot.Message) error {
+	args := strings.SplitN(strings.TrimSpace(message.Vars["args"]), " ", 2)
+	if len(args) < 2 {
+		return parseError(editUsage)
+	}
+	text := args[1]
+	words := strings.SplitN(text, " ", 2)
+	series := len(words) == 2 && isSeriesWord(words[0])
+	if series {
+		text = words[1]
+	}
+	changes, err := parseChanges(text)
+	if err != nil {
+		return err
+	}
+
+	ref, err := resolveRef(message.ChatID, args[0])
+	if err != nil {
+		return err
+	}
+	backend, calendarID, err := chatBackend(message.ChatID, message.From.ID)
+	if err != nil {
+		return err
+	}
+	evt, err := currentEvent(backend, ref)
+	if err != nil {
+		return err
+	}
+	if series {
+		if ref, evt, err = seriesEvent(backend, ref); err != nil {
+			return err
+		}
+	}
+	loc, err := userLocation(message.From.ID, backend, calendarID)
+	if err != nil {
+		return err
+	}
+
+	patch := &calendar.Event{
+		Summary:     changes["name"],
+		Location:    changes["location"],
+		Description: changes["description"],
+	}
+	if _, ok := changes["name"]; ok && patch.Summary == "" {
+		return parseError("Der Name eines Termins darf nicht leer sein.")
+	}
+	if err := editTime(evt, patch, changes, loc); err != nil {
+		return err
+	}
+
+	updated, err := backend.Patch(ref.CalendarID, ref.EventID, evt.Etag, patch, guestUpdates(evt))
+	if err != nil {
+		return err
+	}
+	if _, err := issueRefs(message.ChatID, ref.CalendarID, []*calendar.Event{updated}); err != nil {
+		return err
+	}
+	if series {
+		message.Reply("Serie geändert"+byMember(message)+":\n"+formatEventDetails(updated, loc), tbot.DisablePreview)
+		return nil
+	}
+	message.Reply("Termin geändert"+byMember(message)+":\n"+formatEventDetails(updated, loc), tbot.DisablePreview)
+	return nil
+}
+
+// editTime sets start and end of patch from the time and duration changes.
+// A new time without an end keeps the duration of the event, a new date
+// without a time keeps the time of day of a timed event, and a new time
+// without a date keeps the day of the event.
+func editTime(evt, patch *calendar.Event, changes map[string]string, loc *time.Location) error {
+	when, hasTime := changes["time"]
+	length, hasDuration := changes["duration"]
+	if !hasTime && !hasDuration {
+		return nil
+	}
+
+	allDay := evt.Start.DateTime == ""
+	start := eventTime(evt.Start)
+	end := eventTime(evt.End)
+	if !allDay {
+		start = start.In(loc)
+		end = end.In(loc)
+	} else {
+		start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+		end = time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, loc)
+	}
+
+	if hasTime {
+		parsed, err := parser.ParseTime(when, time.Now().In(loc))
+		if err != nil || parsed.Name != "" {
+			return parseError("Die Zeit %q habe ich nicht verstanden, z.B. zeit: morgen 10-11 oder zeit: 20/03.", when)
+		}
+		if parsed.TimeOnly {
+			//a time of day alone is meant on the day of the event, not today
+			days := daysBetween(parsed.Start, start)
+			parsed.Start = parsed.Start.AddDate(0, 0, days)
+			parsed.End = parsed.End.AddDate(0, 0, days)
+		}
+		days := daysBetween(start, parsed.Start)
+		switch {
+		case parsed.AllDay && sameDay(parsed.Start, parsed.End.AddDate(0, 0, -1)):
+			//only a date, move the event keeping its time of day or number of days
+			start = start.AddDate(0, 0, days)
+			end = end.AddDate(0, 0, days)
+		case parsed.DefaultEnd && !allDay:
+			end = parsed.Start.Add(end.Sub(start))
+			start = parsed.Start
+		default:
+			start, end = parsed.Start, parsed.End
+			allDay = parsed.AllDay
+		}
+	}
+
+	if hasDuration {
+		d, err := parser.ParseDuration(length)
+		if err != nil {
+			return parseError("Die Dauer %q habe ich nicht verstanden, z.B. dauer: 90min oder dauer: 2 Tage.", length)
+		}
+		if allDay {
+			days := int(d / (24 * time.Hour))
+			if days < 1 || d%(24*time.Hour) != 0 {
+				return parseError("Ganztägige Termine dauern ganze Tage, z.B. dauer: 2 Tage.")
+			}
+			end = start.AddDate(0, 0, days)
+		} else {
+			end = start.Add(d)
+		}
+	}
+
+	patch.Start = newDateTime(start, allDay)
+	patch.End = newDateTime(end, allDay)
+	//a patch merges the old and new values, so switching between
+	//timed and all-day events has to clear the other field
+	for _, dt := range []*calendar.EventDateTime{patch.Start, patch.End} {
+		if allDay {
+			dt.NullFields = []string{"DateTime", "TimeZone"}
+		} else {
+			dt.NullFields = []string{"Date"}
+		}
+	}
+	return nil
+}
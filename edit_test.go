@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yanzay/tbot"
+)
+
+func TestEditTimeKeepsDay(t *testing.T) {
+	setupHandlers(t)
+	loc := testLocation(t)
+	day := time.Now().In(loc).AddDate(0, 0, 10).Format(dateFormat)
+
+	tests := []struct {
+		add, time string
+		want      string
+	}{
+		//a time alone stays on the day of the event and keeps its duration
+		{"Review " + day + " 10-12", "15:00", day + " 15:00-17:00"},
+		{"Review " + day + " 10:00", "8-9:30", day + " 08:00-09:30"},
+		{"Urlaub " + day, "9-12", day + " 09:00-12:00"},
+		//a date alone keeps the time of day
+		{"Review " + day + " 10:00", "übermorgen", time.Now().In(loc).AddDate(0, 0, 2).Format(dateFormat) + " 10:00-11:00"},
+	}
+	for _, tt := range tests {
+		handle := replyHandle(t, sendOne(t, CreateTaskHandler, tbot.MessageVars{"eventstring": tt.add}))
+		reply := sendOne(t, EditHandler, tbot.MessageVars{"args": handle + " zeit: " + tt.time})
+		wantContains(t, reply, "Termin geändert", tt.want)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/yanzay/tbot"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// errorKind classifies why a command failed.
+type errorKind int
+
+const (
+	kindParse    errorKind = iota // the user input could not be understood
+	kindNotFound                  // the referenced event does not exist
+	kindAPI                       // the calendar could not be reached
+	kindAuth                      // the user's Google access is missing or expired
+	kindConflict                  // the event changed in the meantime
+	kindDenied                    // the user may not do this in the chat
+)
+
+// defaultMessages are the replies for errors without a more specific message.
+var defaultMessages = map[errorKind]string{
+	kindParse:    "Das habe ich nicht verstanden, bitte prüfe deine Eingabe.",
+	kindNotFound: "Diesen Termin gibt es nicht (mehr).",
+	kindAPI:      "Der Kalender ist gerade nicht erreichbar, bitte versuche es später noch einmal.",
+	kindAuth:     "Der Zugriff auf deinen Google Kalender ist abgelaufen oder wurde widerrufen. Bitte verbinde ihn mit /connect neu.",
+	kindConflict: "Der Termin wurde inzwischen geändert, bitte sieh ihn dir mit /show noch einmal an.",
+	kindDenied:   "Das darfst du in diesem Chat nicht.",
+}
+
+// internalMessage is the reply for unexpected failures.
+const internalMessage = "Da ist etwas schiefgelaufen, bitte versuche es noch einmal."
+
+// botError is an error of a command that is reported back to the user.
+type botError struct {
+	kind errorKind
+	msg  string // reply for the user, the kind's default if empty
+	err  error  // underlying cause, may be nil
+}
+
+func (e *botError) Error() string {
+	switch {
+	case e.err != nil && e.msg != "":
+		return e.msg + ": " + e.err.Error()
+	case e.err != nil:
+		return e.err.Error()
+	}
+	return e.msg
+}
+
+func (e *botError) Unwrap() error {
+	return e.err
+}
+
+// reply returns the message shown to the user.
+func (e *botError) reply() string {
+	if e.msg != "" {
+		return e.msg
+	}
+	return defaultMessages[e.kind]
+}
+
+// parseError reports user input that could not be understood.
+func parseError(format string, args ...interface{}) error {
+	return &botError{kind: kindParse, msg: fmt.Sprintf(format, args...)}
+}
+
+// notFoundError reports a reference to an event that does not exist.
+func notFoundError(format string, args ...interface{}) error {
+	return &botError{kind: kindNotFound, msg: fmt.Sprintf(format, args...)}
+}
+
+// errNotConnected is returned when a Telegram user has not linked a Google account yet.
+var errNotConnected = &botError{kind: kindAuth, msg: "Bitte verbinde zuerst deinen Google Kalender mit /connect."}
+
+// classify turns any error into a botError. Errors of the Calendar API
+// and of the token refresh are mapped to the matching kind.
+func classify(err error) *botError {
+	var be *botError
+	if errors.As(err, &be) {
+		return be
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return &botError{kind: kindAuth, err: err}
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusUnauthorized:
+			return &botError{kind: kindAuth, err: err}
+		case http.StatusNotFound, http.StatusGone:
+			return &botError{kind: kindNotFound, err: err}
+		case http.StatusPreconditionFailed:
+			return &botError{kind: kindConflict, err: err}
+		}
+	}
+	return &botError{kind: kindAPI, err: err}
+}
+
+// isGone reports whether err is the 410 Gone the Calendar API answers
+// expired sync tokens with.
+func isGone(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusGone
+}
+
+// handle adapts a command handler returning an error to tbot.
+// Failures are logged and answered with a message for the user
+// instead of stopping the bot.
+func handle(h func(*tbot.Message) error) tbot.HandlerFunction {
+	return func(message *tbot.Message) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Panic handling %q of user %v: %v\n%s", message.Data, senderID(message), r, debug.Stack())
+				message.Reply(internalMessage)
+			}
+		}()
+
+		if err := h(message); err != nil {
+			be := classify(err)
+			log.Printf("Error handling %q of user %v: %v", message.Data, senderID(message), err)
+			message.Reply(be.reply())
+		}
+	}
+}
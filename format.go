@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/clndr/parser"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	dateFormat     = "02/01/2006"
+	dateTimeFormat = "02/01/2006 15:04"
+	clockFormat    = "15:04"
+)
+
+// formatEventTime describes when an event takes place in loc, e.g.
+// "12/03/2025 10:00-11:00", "12/03/2025, ganztägig" or "12/03/2025-15/03/2025".
+// All-day events are shown on their dates regardless of loc.
+func formatEventTime(item *calendar.Event, loc *time.Location) string {
+	start := eventTime(item.Start)
+	end := eventTime(item.End)
+
+	if item.Start.DateTime == "" {
+		//the end date of all-day events is exclusive
+		last := end.AddDate(0, 0, -1)
+		if !last.After(start) {
+			return start.Format(dateFormat) + ", ganztägig"
+		}
+		return start.Format(dateFormat) + "-" + last.Format(dateFormat)
+	}
+
+	start = start.In(loc)
+	end = end.In(loc)
+	if !sameDay(start, end) {
+		return start.Format(dateTimeFormat) + "-" + end.Format(dateTimeFormat)
+	}
+	return start.Format(dateTimeFormat) + "-" + end.Format(clockFormat)
+}
+
+// formatParsedTime describes when an event that is about to be created takes place.
+func formatParsedTime(start, end time.Time, allDay bool) string {
+	if allDay {
+		return formatEventTime(&calendar.Event{
+			Start: &calendar.EventDateTime{Date: start.Format("2006-01-02")},
+			End:   &calendar.EventDateTime{Date: end.Format("2006-01-02")},
+		}, start.Location())
+	}
+	return formatEventTime(&calendar.Event{
+		Start: &calendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+		End:   &calendar.EventDateTime{DateTime: end.Format(time.RFC3339)},
+	}, start.Location())
+}
+
+// formatEventDetails describes all details of an event worth showing in a chat.
+func formatEventDetails(item *calendar.Event, loc *time.Location) string {
+	details := item.Summary + "\nWann: " + formatEventTime(item, loc)
+	if rule, ok := parseRRule(item.Recurrence); ok {
+		details += "\nWiederholung: " + rule.describe(loc)
+	}
+	if item.Location != "" {
+		details += "\nWo: " + item.Location
+	}
+	if len(item.Attendees) > 0 {
+		details += "\nGäste: " + formatGuests(item.Attendees)
+	}
+	if name := createdBy(item); name != "" {
+		details += "\nEingetragen von: " + name
+	}
+	if item.Description != "" {
+		details += "\n\n" + item.Description
+	}
+	if item.HtmlLink != "" {
+		details += "\n\n" + item.HtmlLink
+	}
+	return details
+}
+
+// formatPeriod describes a period asked about, e.g. "am Mittwoch, 12/03/2025",
+// "vom 17/03/2025 bis 23/03/2025" or "am 13/03/2025 12:00-18:00".
+func formatPeriod(period *parser.Period) string {
+	if !period.AllDay && !sameDay(period.Start, period.End) {
+		return "vom " + period.Start.Format(dateTimeFormat) + " bis " + period.End.Format(dateTimeFormat)
+	}
+	if !period.AllDay {
+		return "am " + formatParsedTime(period.Start, period.End, false)
+	}
+	last := period.End.AddDate(0, 0, -1)
+	if !last.After(period.Start) {
+		return "am " + weekdayNames[period.Start.Weekday()] + ", " + period.Start.Format(dateFormat)
+	}
+	return "vom " + period.Start.Format(dateFormat) + " bis " + last.Format(dateFormat)
+}
+
+// sameDay reports whether a and b fall on the same calendar day.
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// formatAgenda lists events with their handles grouped under a header for
+// each day, e.g. "Montag, 17/03/2025". Events starting before from are
+// listed under the day of from.
+func formatAgenda(events []*calendar.Event, handles []string, from time.Time, loc *time.Location) string {
+	var lines []string
+	var day time.Time
+	for i, item := range events {
+		start := eventStart(item, loc).In(loc)
+		if start.Before(from) {
+			start = from.In(loc)
+		}
+		if day.IsZero() || !sameDay(day, start) {
+			if !day.IsZero() {
+				lines = append(lines, "")
+			}
+			day = start
+			lines = append(lines, weekdayNames[day.Weekday()]+", "+day.Format(dateFormat))
+		}
+		lines = append(lines, fmt.Sprintf("[%v] %v %v", handles[i], formatAgendaTime(item, loc), item.Summary))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatAgendaTime describes when an event takes place below its day header,
+// e.g. "10:00-11:00" or "ganztägig".
+func formatAgendaTime(item *calendar.Event, loc *time.Location) string {
+	if item.Start.DateTime == "" {
+		last := eventTime(item.End).AddDate(0, 0, -1)
+		if last.After(eventTime(item.Start)) {
+			return "bis " + last.Format(dateFormat)
+		}
+		return "ganztägig"
+	}
+	start := eventTime(item.Start).In(loc)
+	end := eventTime(item.End).In(loc)
+	if !sameDay(start, end) {
+		return start.Format(clockFormat) + "-" + end.Format(dateTimeFormat)
+	}
+	return start.Format(clockFormat) + "-" + end.Format(clockFormat)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/clndr/parser"
+	"github.com/yanzay/tbot"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	// minFree is the shortest free window worth mentioning.
+	minFree = 15 * time.Minute
+	// maxFreeDays is the longest period /free looks at.
+	maxFreeDays = 31
+
+	freeUsage = "Bitte gib den Tag oder Zeitraum an, z.B. /free, /free morgen, /free donnerstag nachmittag oder /free nächste woche."
+)
+
+// defaultWorkHours are the working hours of users who did not set their own
+// with /workhours, from WORKHOURS or else 09:00-17:00.
+var defaultWorkHours = workHours{start: 9 * time.Hour, end: 17 * time.Hour}
+
+// workHours is the part of a day a user works, as clock time since
+// midnight. They are put on each day with time.Date, so they stay the
+// same on the days the clocks change.
+type workHours struct {
+	start, end time.Duration
+}
+
+func (w workHours) String() string {
+	return formatClock(w.start) + "-" + formatClock(w.end)
+}
+
+// on returns the working hours on the day of t.
+func (w workHours) on(t time.Time) span {
+	y, m, d := t.Date()
+	at := func(since time.Duration) time.Time {
+		return time.Date(y, m, d, int(since/time.Hour), int(since%time.Hour/time.Minute), 0, 0, t.Location())
+	}
+	return span{start: at(w.start), end: at(w.end)}
+}
+
+// formatClock formats a time since midnight like "09:30".
+func formatClock(since time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(since/time.Hour), int(since%time.Hour/time.Minute))
+}
+
+// parseWorkHours parses working hours like "9-17" or "08:30-16:30".
+func parseWorkHours(input string) (workHours, error) {
+	now := time.Now()
+	//parsed from midnight, so the hours stay on today however late it is
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	parsed, err := parser.ParseTime(input, midnight)
+	if err != nil || parsed.AllDay || parsed.DefaultEnd || parsed.Name != "" || len(parsed.Recurrence) > 0 ||
+		!sameDay(parsed.Start, midnight) || !sameDay(parsed.Start, parsed.End) {
+		return workHours{}, fmt.Errorf("%q are no working hours like 09:00-17:00", input)
+	}
+	return workHours{start: sinceMidnight(parsed.Start), end: sinceMidnight(parsed.End)}, nil
+}
+
+// sinceMidnight returns the clock time of t as time since midnight,
+// which differs from the time passed on days changing the clocks.
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+}
+
+// loadWorkHours reads the default working hours from WORKHOURS.
+func loadWorkHours() error {
+	hours := os.Getenv("WORKHOURS")
+	if hours == "" {
+		return nil
+	}
+	w, err := parseWorkHours(hours)
+	if err != nil {
+		return fmt.Errorf("WORKHOURS: %v", err)
+	}
+	defaultWorkHours = w
+	return nil
+}
+
+// userWorkHours returns the working hours of a user.
+func userWorkHours(userID int) (workHours, error) {
+	u, err := loadUser(userID)
+	if err != nil {
+		return workHours{}, err
+	}
+	if u.WorkHours == "" {
+		return defaultWorkHours, nil
+	}
+	return parseWorkHours(u.WorkHours)
+}
+
+// span is a stretch of time, e.g. a free window or a busy period.
+type span struct {
+	start, end time.Time
+}
+
+// busySpans converts the busy periods of the Calendar API.
+func busySpans(periods []*calendar.TimePeriod) ([]span, error) {
+	var busy []span
+	for _, p := range periods {
+		start, err := time.Parse(time.RFC3339, p.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := time.Parse(time.RFC3339, p.End)
+		if err != nil {
+			return nil, err
+		}
+		busy = append(busy, span{start: start, end: end})
+	}
+	return busy, nil
+}
+
+// workWindows returns the stretches of a period to look for free time in,
+// one per day: the working hours of each day, or the period itself if it
+// has times. Weekends are left out of periods with working days, and
+// everything is cut off at now, rounded up to the next quarter hour.
+func workWindows(period *parser.Period, hours workHours, now time.Time) []span {
+	now = now.Add(minFree - 1).Truncate(minFree)
+	var windows []span
+	if !period.AllDay {
+		windows = append(windows, span{start: period.Start, end: period.End})
+	} else {
+		workdays := false
+		for day := period.Start; day.Before(period.End); day = day.AddDate(0, 0, 1) {
+			workdays = workdays || !isWeekend(day)
+		}
+		for day := period.Start; day.Before(period.End); day = day.AddDate(0, 0, 1) {
+			if !workdays || !isWeekend(day) {
+				windows = append(windows, hours.on(day))
+			}
+		}
+	}
+
+	var open []span
+	for _, w := range windows {
+		if w.start.Before(now) {
+			w.start = now
+		}
+		if w.end.After(w.start) {
+			open = append(open, w)
+		}
+	}
+	return open
+}
+
+func isWeekend(day time.Time) bool {
+	return day.Weekday() == time.Saturday || day.Weekday() == time.Sunday
+}
+
+// freeWindows returns what is left of windows after taking out the busy
+// spans, leaving out gaps shorter than min.
+func freeWindows(windows, busy []span, min time.Duration) []span {
+	sort.Slice(busy, func(i, j int) bool { return busy[i].start.Before(busy[j].start) })
+	var free []span
+	for _, w := range windows {
+		start := w.start
+		for _, b := range busy {
+			if !b.end.After(start) || !b.start.Before(w.end) {
+				continue
+			}
+			if b.start.Sub(start) >= min {
+				free = append(free, span{start: start, end: b.start})
+			}
+			start = b.end
+		}
+		if w.end.Sub(start) >= min {
+			free = append(free, span{start: start, end: w.end})
+		}
+	}
+	return free
+}
+
+// formatFree lists the free windows under a header for each day of windows,
+// e.g. "Montag, 17/03/2025".
+func formatFree(windows, free []span, loc *time.Location) string {
+	var lines []string
+	var day time.Time
+	for _, w := range windows {
+		start := w.start.In(loc)
+		if !day.IsZero() && sameDay(day, start) {
+			continue
+		}
+		if !day.IsZero() {
+			lines = append(lines, "")
+		}
+		day = start
+		lines = append(lines, weekdayNames[day.Weekday()]+", "+day.Format(dateFormat))
+		found := false
+		for _, f := range free {
+			if sameDay(f.start.In(loc), day) {
+				lines = append(lines, f.start.In(loc).Format(clockFormat)+"-"+f.end.In(loc).Format(clockFormat))
+				found = true
+			}
+		}
+		if !found {
+			lines = append(lines, "keine freie Zeit")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FreeHandler lists the free time of a day or period within the working
+// hours for /free {period}, e.g. "/free donnerstag nachmittag" or
+// "/free nächste woche in:Team". Without period it looks at today.
+func FreeHandler(message *tbot.Message) error {
+	text, calName := takeCalendar(strings.TrimSpace(message.Vars["period"]))
+	backend, calendarID, err := chatBackend(message.ChatID, message.From.ID)
+	if err != nil {
+		return err
+	}
+	calendarID, calName, err = targetCalendar(message.ChatID, backend, calendarID, calName)
+	if err != nil {
+		return err
+	}
+	loc, err := userLocation(message.From.ID, backend, calendarID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().In(loc)
+	period, err := parser.ParsePeriod(text, now)
+	if err != nil {
+		return parseError(freeUsage)
+	}
+	if period.End.Sub(period.Start) > maxFreeDays*24*time.Hour {
+		return parseError("Ich kann höchstens %v Tage auf einmal durchsehen.", maxFreeDays)
+	}
+	hours, err := userWorkHours(message.From.ID)
+	if err != nil {
+		return err
+	}
+
+	windows := workWindows(period, hours, now)
+	if len(windows) == 0 {
+		message.Reply("Dieser Zeitraum ist schon vorbei.")
+		return nil
+	}
+	periods, err := backend.Busy([]string{calendarID}, windows[0].start, windows[len(windows)-1].end)
+	if err != nil {
+		return err
+	}
+	busy, err := busySpans(periods)
+	if err != nil {
+		return err
+	}
+
+	header := "Freie Zeiten"
+	if calName != "" {
+		header += " in " + calName
+	}
+	if period.AllDay {
+		header += " (Arbeitszeit " + hours.String() + ")"
+	}
+	message.Reply(header + ":\n" + formatFree(windows, freeWindows(windows, busy, minFree), loc))
+	return nil
+}
+
+// WorkhoursHandler shows the working hours /free looks at for /workhours,
+// sets them for /workhours {hours}, e.g. "/workhours 08:30-16:30", and goes
+// back to the default for /workhours auto.
+func WorkhoursHandler(message *tbot.Message) error {
+	input := strings.TrimSpace(message.Vars["hours"])
+	if input == "" {
+		hours, err := userWorkHours(message.From.ID)
+		if err != nil {
+			return err
+		}
+		message.Replyf("Deine Arbeitszeit ist %v. Ändern kannst du sie z.B. mit /workhours 08:30-16:30, "+
+			"mit /workhours auto gilt wieder %v.", hours, defaultWorkHours)
+		return nil
+	}
+
+	stored := ""
+	if !strings.EqualFold(input, "auto") {
+		hours, err := parseWorkHours(input)
+		if err != nil {
+			return parseError("Bitte gib die Arbeitszeit als Uhrzeiten an, z.B. /workhours 08:30-16:30.")
+		}
+		stored = hours.String()
+	}
+	if err := updateUser(message.From.ID, func(u *user) { u.WorkHours = stored }); err != nil {
+		return err
+	}
+	if stored == "" {
+		message.Replyf("Es gilt wieder die Arbeitszeit %v.", defaultWorkHours)
+	} else {
+		message.Replyf("Deine Arbeitszeit ist jetzt %v.", stored)
+	}
+	return nil
+}
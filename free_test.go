@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clndr/parser"
+)
+
+const testLayout = "2006-01-02 15:04"
+
+func testLocation(t *testing.T) *time.Location {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+// formatSpans formats spans like "2025-03-12 09:00-17:00, ...".
+func formatSpans(spans []span) string {
+	var parts []string
+	for _, s := range spans {
+		parts = append(parts, s.start.Format(testLayout)+"-"+s.end.Format(clockFormat))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestWorkWindows(t *testing.T) {
+	loc := testLocation(t)
+	at := func(s string) time.Time {
+		d, err := time.ParseInLocation(testLayout, s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		start, end string
+		allDay     bool
+		now        string
+		want       string
+	}{
+		//the rest of the week without the weekend, from now on
+		{"2025-03-12 00:00", "2025-03-17 00:00", true, "2025-03-12 11:17",
+			"2025-03-12 11:30-17:00, 2025-03-13 09:00-17:00, 2025-03-14 09:00-17:00"},
+		//a weekend on its own
+		{"2025-03-15 00:00", "2025-03-17 00:00", true, "2025-03-12 11:17",
+			"2025-03-15 09:00-17:00, 2025-03-16 09:00-17:00"},
+		//periods with times are taken as they are
+		{"2025-03-13 12:00", "2025-03-13 18:00", false, "2025-03-12 11:17", "2025-03-13 12:00-18:00"},
+		{"2025-03-12 12:00", "2025-03-12 18:00", false, "2025-03-12 13:05", "2025-03-12 13:15-18:00"},
+		//a day that is over
+		{"2025-03-12 00:00", "2025-03-13 00:00", true, "2025-03-12 17:30", ""},
+		//the days the clocks change, 23 and 25 hours long
+		{"2025-03-30 00:00", "2025-03-31 00:00", true, "2025-03-28 10:00", "2025-03-30 09:00-17:00"},
+		{"2025-10-26 00:00", "2025-10-27 00:00", true, "2025-10-24 10:00", "2025-10-26 09:00-17:00"},
+	}
+
+	hours := workHours{start: 9 * time.Hour, end: 17 * time.Hour}
+	for _, tt := range tests {
+		period := &parser.Period{Start: at(tt.start), End: at(tt.end), AllDay: tt.allDay}
+		if got := formatSpans(workWindows(period, hours, at(tt.now))); got != tt.want {
+			t.Errorf("workWindows(%v - %v) at %v = %q, want %q", tt.start, tt.end, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestParseWorkHours(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"9-17", "09:00-17:00"},
+		{"08:30-16:30", "08:30-16:30"},
+		{"7:15-12", "07:15-12:00"},
+	}
+	for _, tt := range tests {
+		w, err := parseWorkHours(tt.input)
+		if err != nil || w.String() != tt.want {
+			t.Errorf("parseWorkHours(%q) = %v, %v, want %v", tt.input, w, err, tt.want)
+		}
+	}
+	for _, input := range []string{"9", "morgen 9-17", "Arbeit 9-17", "22-6"} {
+		if w, err := parseWorkHours(input); err == nil {
+			t.Errorf("parseWorkHours(%q) = %v, want an error", input, w)
+		}
+	}
+}
+
+func TestFreeWindows(t *testing.T) {
+	loc := testLocation(t)
+	clock := func(s string) time.Time {
+		d, err := time.ParseInLocation(testLayout, "2025-03-13 "+s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	spans := func(s string) []span {
+		var spans []span
+		for _, part := range strings.Fields(s) {
+			times := strings.Split(part, "-")
+			spans = append(spans, span{start: clock(times[0]), end: clock(times[1])})
+		}
+		return spans
+	}
+
+	tests := []struct {
+		windows, busy string
+		want          string
+	}{
+		{"09:00-17:00", "", "2025-03-13 09:00-17:00"},
+		{"09:00-17:00", "10:00-11:00", "2025-03-13 09:00-10:00, 2025-03-13 11:00-17:00"},
+		//overlapping and unsorted busy times, and busy times past the window
+		{"09:00-17:00", "16:50-17:30 10:30-12:00 10:00-11:00", "2025-03-13 09:00-10:00, 2025-03-13 12:00-16:50"},
+		//gaps shorter than 15 minutes are left out
+		{"09:00-17:00", "09:10-12:00 12:10-16:00", "2025-03-13 16:00-17:00"},
+		{"09:00-12:00 13:00-17:00", "11:00-14:00", "2025-03-13 09:00-11:00, 2025-03-13 14:00-17:00"},
+		{"09:00-17:00", "08:00-18:00", ""},
+	}
+
+	for _, tt := range tests {
+		if got := formatSpans(freeWindows(spans(tt.windows), spans(tt.busy), minFree)); got != tt.want {
+			t.Errorf("freeWindows(%v, %v) = %q, want %q", tt.windows, tt.busy, got, tt.want)
+		}
+	}
+}
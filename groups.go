@@ -0,0 +1,198 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/yanzay/tbot"
+	"google.golang.org/api/calendar/v3"
+)
+
+const groupsBucket = "groups"
+
+// Extended properties of events added from a group chat, naming
+// the member who added them.
+const (
+	createdByIDProperty   = "clndrCreatedById"
+	createdByNameProperty = "clndrCreatedBy"
+)
+
+// telegram talks to the Bot API directly for what tbot does not offer,
+// like asking who administers a group.
+var telegram *tgbotapi.BotAPI
+
+// group is a group chat bound to a shared calendar. All members work on
+// that calendar through the Google account of the admin who bound it.
+type group struct {
+	ChatID       int64     `json:"chat_id"`
+	CalendarID   string    `json:"calendar_id"`
+	CalendarName string    `json:"calendar_name"`
+	OwnerID      int       `json:"owner_id"` // user whose Google account is used
+	Bound        time.Time `json:"bound"`
+}
+
+// loadGroup returns the binding of a chat, ok is false for unbound chats.
+func loadGroup(chatID int64) (*group, bool, error) {
+	g := &group{}
+	ok, err := store.Get(groupsBucket, strconv.FormatInt(chatID, 10), g)
+	return g, ok, err
+}
+
+// chatBackend returns the backend commands of a user in a chat act through
+// and the calendar they use by default: the calendar bound to the chat
+// through the account of whoever bound it, or else the user's own.
+func chatBackend(chatID int64, userID int) (CalendarBackend, string, error) {
+	g, ok, err := loadGroup(chatID)
+	if err != nil {
+		return nil, "", err
+	}
+	if ok {
+		backend, err := backendFor(g.OwnerID)
+		if err != nil && classify(err).kind == kindAuth {
+			return nil, "", &botError{kind: kindAuth, err: err,
+				msg: "Der Kalender dieser Gruppe ist nicht mehr verbunden, ein Admin muss ihn mit /bind neu verbinden."}
+		}
+		return backend, g.CalendarID, err
+	}
+
+	backend, err := backendFor(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	u, err := loadUser(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	return backend, u.calendar(), nil
+}
+
+// chatOwner returns the user whose Google account commands of a user in a
+// chat act through: whoever bound the chat to a calendar, or else the user.
+func chatOwner(chatID int64, userID int) (int, error) {
+	g, ok, err := loadGroup(chatID)
+	if err != nil || !ok {
+		return userID, err
+	}
+	return g.OwnerID, nil
+}
+
+// isGroupChat reports whether a message was sent in a group, which
+// Telegram gives negative chat IDs.
+func isGroupChat(message *tbot.Message) bool {
+	return message.ChatID < 0
+}
+
+// isChatAdmin reports whether a user administers a group chat.
+func isChatAdmin(chatID int64, userID int) (bool, error) {
+	member, err := telegram.GetChatMember(tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID})
+	if err != nil {
+		return false, err
+	}
+	return member.IsCreator() || member.IsAdministrator(), nil
+}
+
+// senderName returns how the sender of a message is mentioned in replies:
+// their @username, or their first name if they have none.
+func senderName(message *tbot.Message) string {
+	from := sender(message)
+	if from.UserName != "" {
+		return "@" + from.UserName
+	}
+	return from.FirstName
+}
+
+// byMember returns " von <member>" for replies in a bound group chat, so
+// everybody sees who made a change, and nothing elsewhere.
+func byMember(message *tbot.Message) string {
+	if _, ok, err := loadGroup(message.ChatID); err != nil || !ok {
+		return ""
+	}
+	return " von " + senderName(message)
+}
+
+// attribute records the member of a bound group who adds evt.
+func attribute(message *tbot.Message, evt *calendar.Event) {
+	if _, ok, err := loadGroup(message.ChatID); err != nil || !ok {
+		return
+	}
+	evt.ExtendedProperties = &calendar.EventExtendedProperties{
+		Private: map[string]string{
+			createdByIDProperty:   strconv.Itoa(senderID(message)),
+			createdByNameProperty: senderName(message),
+		},
+	}
+}
+
+// createdBy returns the member of a group chat who added evt, if any.
+func createdBy(evt *calendar.Event) string {
+	if evt.ExtendedProperties == nil {
+		return ""
+	}
+	return evt.ExtendedProperties.Private[createdByNameProperty]
+}
+
+// BindHandler binds a group chat to one of the sender's calendars for
+// /bind <calendar>, shows the binding for /bind and removes it for /bind aus.
+// Only admins of the group may change the binding.
+func BindHandler(message *tbot.Message) error {
+	if !isGroupChat(message) {
+		return parseError("/bind verbindet eine Gruppe mit einem Kalender, bitte sende es in der Gruppe.")
+	}
+	name := strings.Trim(strings.TrimSpace(message.Vars["name"]), `"`)
+	g, bound, err := loadGroup(message.ChatID)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		if !bound {
+			message.Reply("Diese Gruppe ist mit keinem Kalender verbunden. Ein Admin kann das mit /bind <Kalender> ändern.")
+			return nil
+		}
+		message.Replyf("Diese Gruppe verwendet den Kalender %v.", g.CalendarName)
+		return nil
+	}
+
+	admin, err := isChatAdmin(message.ChatID, message.From.ID)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return &botError{kind: kindDenied, msg: "Nur Admins der Gruppe können den Kalender der Gruppe ändern."}
+	}
+
+	key := strconv.FormatInt(message.ChatID, 10)
+	if isOff(name) {
+		if err := store.Delete(groupsBucket, key); err != nil {
+			return err
+		}
+		message.Reply("Die Gruppe ist mit keinem Kalender mehr verbunden, jeder verwendet wieder seinen eigenen.")
+		return nil
+	}
+
+	backend, err := backendFor(message.From.ID)
+	if err != nil {
+		return err
+	}
+	entry, err := findCalendar(backend, name)
+	if err != nil {
+		return err
+	}
+	if isReadOnly(entry) {
+		return parseError("In den Kalender %v kannst du keine Termine eintragen, bitte wähle einen anderen.", calendarName(entry))
+	}
+	g = &group{
+		ChatID:       message.ChatID,
+		CalendarID:   entry.Id,
+		CalendarName: calendarName(entry),
+		OwnerID:      message.From.ID,
+		Bound:        time.Now(),
+	}
+	if err := store.Put(groupsBucket, key, g); err != nil {
+		return err
+	}
+	message.Replyf("Diese Gruppe verwendet jetzt den Kalender %v über das Google Konto von %v. "+
+		"Alle Mitglieder können mit /add, /show, /edit und /delete darin arbeiten.", g.CalendarName, senderName(message))
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yanzay/tbot"
+)
+
+// healthPath answers health checks of the hosting platform.
+const healthPath = "/healthz"
+
+// webhookListenAddr is handed to tbot in webhook mode. tbot starts its own
+// server on the address it is given, serving its handler on
+// http.DefaultServeMux for any path. Telegram's updates reach that handler
+// through startHTTPServer instead, which checks the secret path, so tbot's
+// server is given an address it fails to listen on.
+const webhookListenAddr = "localhost:-1"
+
+// started is when the bot started, reported by the health check.
+var started = time.Now()
+
+// webhookOptions returns the tbot options for receiving updates. With
+// WEBHOOKURL set, Telegram posts updates to WEBHOOKURL followed by the
+// secret path WEBHOOKPATH, which defaults to one derived from the bot
+// token. Otherwise the bot polls for updates and path is empty.
+func webhookOptions(token string) (options []tbot.ServerOption, path string, err error) {
+	base := strings.TrimSuffix(os.Getenv("WEBHOOKURL"), "/")
+	if base == "" {
+		return nil, "", nil
+	}
+	if u, err := url.Parse(base); err != nil || u.Scheme != "https" {
+		return nil, "", fmt.Errorf("WEBHOOKURL must be an https URL, got %q", base)
+	}
+	path = os.Getenv("WEBHOOKPATH")
+	if path == "" {
+		sum := sha256.Sum256([]byte(token))
+		path = "/telegram/" + hex.EncodeToString(sum[:16])
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return []tbot.ServerOption{tbot.WithWebhook(base+path, webhookListenAddr)}, path, nil
+}
+
+// startHTTPServer serves health checks on healthPath, Telegram's updates on
+// webhookPath unless it is empty, the oauth callback on the path of
+// REDIRECTURL and, if PUBLICURL is set, push notifications of calendars on
+// notificationPath. It listens on $PORT, or :8080 if PORT is not set, with
+// TLS if TLSCERT and TLSKEY name a certificate and its key.
+func startHTTPServer(webhookPath string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(healthPath, healthHandler)
+
+	if webhookPath != "" {
+		//tbot registers its handler for updates on the default mux
+		mux.Handle(webhookPath, http.DefaultServeMux)
+	}
+	redirect, err := url.Parse(oauthConfig.RedirectURL)
+	if err != nil || oauthConfig.RedirectURL == "" {
+		log.Println("REDIRECTURL not usable, codes have to be sent with /connect <code>")
+	} else {
+		path := redirect.Path
+		if path == "" {
+			path = "/"
+		}
+		mux.HandleFunc(path, oauthCallbackHandler)
+	}
+	if publicURL != "" {
+		mux.HandleFunc(notificationPath, notificationHandler)
+	}
+
+	addr := ":8080"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+	cert, key := os.Getenv("TLSCERT"), os.Getenv("TLSKEY")
+	go func() {
+		log.Printf("Listening for http requests on %v", addr)
+		if cert != "" && key != "" {
+			log.Fatal(http.ListenAndServeTLS(addr, cert, key, mux))
+		} else {
+			log.Fatal(http.ListenAndServe(addr, mux))
+		}
+	}()
+}
+
+// healthHandler reports that the bot is running and its store is readable.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := store.Keys(usersBucket); err != nil {
+		http.Error(w, "store: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "ok, up %v\n", time.Since(started).Truncate(time.Second))
+}
@@ -3,49 +3,111 @@ package main
 import (
 	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/clndr/parser"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/yanzay/tbot"
-	"golang.org/x/net/context"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
 )
 
 /* global variables */
 var (
-	srv        *calendar.Service
+	memory     *memoryBackend
+	store      Store
+	todos      TodoStore
 	calendarId string
 	bot        *tbot.Server
 )
 
 func main() {
 
-	// get the telegram bot token, the google calendar client and the calendar ID
+	// get the telegram bot token and the calendar ID, every user
+	// connects their own Google account with /connect
 	token := os.Getenv("BOTTOKEN")
 	calendarId = os.Getenv("CALENDARID")
-	google_client := getGoogleClient()
+	if calendarId == "" {
+		calendarId = "primary"
+	}
+	oauthConfig = newOAuthConfig()
+	//push notifications of calendar changes need the bot to be reachable at PUBLICURL
+	publicURL = strings.TrimSuffix(os.Getenv("PUBLICURL"), "/")
+
+	//BACKEND=memory runs the bot without Google
+	if os.Getenv("BACKEND") == "memory" {
+		memory = newMemoryBackend()
+	}
 
-	//initialize the service for the calendar
+	//linked accounts and tokens are kept in STOREPATH so the bot can restart unattended
 	var err error
-	srv, err = calendar.New(google_client)
+	storePath := os.Getenv("STOREPATH")
+	switch {
+	case storePath != "":
+		store, err = newFileStore(storePath)
+	case memory != nil:
+		store = newMemoryStore()
+	default:
+		store, err = newFileStore("clndr.json")
+	}
 	checkError(err)
+	todos = newTodoStore(store)
+	checkError(loadACLConfig())
+	checkError(loadWorkHours())
 
-	bot, err = tbot.NewServer(token) //create new server with /help defaulted
+	//updates are polled unless WEBHOOKURL is set
+	options, webhookPath, err := webhookOptions(token)
+	checkError(err)
+	bot, err = tbot.NewServer(token, options...) //create new server with /help defaulted
+	checkError(err)
+	telegram, err = tgbotapi.NewBotAPI(token)
 	checkError(err)
 
 	//run StartHandler if /start command is received
 	bot.HandleFunc("/start", startHandler)
-	bot.HandleFunc("/add {eventstring}", CreateTaskHandler)
-	bot.HandleFunc("/delete {eventstring}", DeleteTaskHandler)
-	bot.HandleFunc("/show {number}", ShowTasksHandler)
-	bot.HandleFunc("/show", ShowTasksHandler)
-	bot.HandleFunc("/todo", TodoHandler)
+	bot.HandleFunc("/connect", handle(ConnectHandler))
+	bot.HandleFunc("/connect {code}", handle(ConnectHandler))
+	bot.HandleFunc("/add {eventstring}", handle(CreateTaskHandler))
+	bot.HandleFunc("/delete {eventstring}", handle(DeleteTaskHandler))
+	bot.HandleFunc("/edit {args}", handle(EditHandler))
+	bot.HandleFunc("/invite {args}", handle(InviteHandler))
+	bot.HandleFunc("/uninvite {args}", handle(UninviteHandler))
+	bot.HandleFunc("/instances {handle}", handle(InstancesHandler))
+	bot.HandleFunc("/reminder", handle(ReminderHandler))
+	bot.HandleFunc("/reminder {minutes}", handle(ReminderHandler))
+	bot.HandleFunc("/digest", handle(DigestHandler))
+	bot.HandleFunc("/digest {args}", handle(DigestHandler))
+	bot.HandleFunc("/show {number}", handle(ShowTasksHandler))
+	bot.HandleFunc("/show", handle(ShowTasksHandler))
+	bot.HandleFunc("/free", handle(FreeHandler))
+	bot.HandleFunc("/free {period}", handle(FreeHandler))
+	bot.HandleFunc("/slot {args}", handle(SlotHandler))
+	bot.HandleFunc("/workhours", handle(WorkhoursHandler))
+	bot.HandleFunc("/workhours {hours}", handle(WorkhoursHandler))
+	bot.HandleFunc("/search {text}", handle(SearchHandler))
+	bot.HandleFunc("/calendars", handle(CalendarsHandler))
+	bot.HandleFunc("/use", handle(UseHandler))
+	bot.HandleFunc("/use {name}", handle(UseHandler))
+	bot.HandleFunc("/bind", handle(BindHandler))
+	bot.HandleFunc("/bind {name}", handle(BindHandler))
+	bot.HandleFunc("/timezone", handle(TimezoneHandler))
+	bot.HandleFunc("/timezone {zone}", handle(TimezoneHandler))
+	bot.HandleFunc("/todo", handle(TodoHandler))
+	bot.HandleFunc("/todo {args}", handle(TodoHandler))
+	bot.HandleFunc("/watch", handle(WatchHandler))
+	bot.HandleFunc("/watch {args}", handle(WatchHandler))
+	bot.HandleFunc("/grant", handle(GrantHandler))
+	bot.HandleFunc("/grant {args}", handle(GrantHandler))
+	bot.HandleFunc("/revoke {args}", handle(RevokeHandler))
+	bot.HandleDefault(handle(CallbackHandler))
+	bot.AddMiddleware(accessControl)
+
+	startHTTPServer(webhookPath)
+	startReminders()
+	startDigests()
+	startChannelRenewal()
 
 	log.Println("Starting Bot..")
 	bot.ListenAndServe() //start server
@@ -53,144 +115,253 @@ func main() {
 }
 
 func startHandler(message *tbot.Message) {
-
+	message.Reply("Hallo! Verbinde zuerst deinen Google Kalender mit /connect, " +
+		"danach kannst du mit /add Termine anlegen und mit /show ansehen.")
 }
 
-func CreateTaskHandler(message *tbot.Message) {
-	user_input := message.Vars["eventstring"]
+func CreateTaskHandler(message *tbot.Message) error {
+	backend, calendarID, err := chatBackend(message.ChatID, message.From.ID)
+	if err != nil {
+		return err
+	}
 
-	//get the whole date in format dd/mm/yyyy or d/m/yyyy, dd/m/yyyy, d/m/yyyy
-	date_expr := regexp.MustCompile("(0?[1-9]|[12][0-9]|3[01])/(0?[1-9]|1[012])/((19|20)\\d\\d)")
-	date := date_expr.FindString(user_input)
+	//times are entered in the user's time zone
+	loc, err := userLocation(message.From.ID, backend, calendarID)
+	if err != nil {
+		return err
+	}
+	text, calName := takeCalendar(message.Vars["eventstring"])
+	calendarID, calName, err = targetCalendar(message.ChatID, backend, calendarID, calName)
+	if err != nil {
+		return err
+	}
+	text, sendUpdates, err := takeNotify(text)
+	if err != nil {
+		return err
+	}
+	text, guests := takeGuests(text)
+	attendees, err := addGuests(nil, guests)
+	if err != nil {
+		return err
+	}
+	parsed, err := parser.Parse(text, time.Now().In(loc))
+	switch err {
+	case nil:
+	case parser.ErrNoName:
+		return parseError("Bitte gib einen Namen für den Termin an, z.B. /add Standup morgen 10-11.")
+	default:
+		return parseError("Bitte gib an, wann der Termin ist, z.B. /add Standup morgen 10-11, " +
+			"/add Zahnarzt am Montag um 14 Uhr oder /add Urlaub 12/03/2025-15/03/2025.")
+	}
 
-	//get the time in format 10:00-12:00
-	time_expr := regexp.MustCompile("([01]?[0-9]|2[0-3]):[0-5][0-9]-([01]?[0-9]|2[0-3]):[0-5][0-9]")
-	compl_time := time_expr.FindString(user_input)
+	//events without a time of day are all-day events, their end date is exclusive
+	start := newDateTime(parsed.Start, parsed.AllDay)
+	end := newDateTime(parsed.End, parsed.AllDay)
 
-	//get the start and end time in format 10:00
-	time_slice_expr := regexp.MustCompile("([01]?[0-9]|2[0-3]):[0-5][0-9]")
-	time_slice := time_slice_expr.FindAllString(compl_time, -1)
-	stime := time_slice[0]
-	etime := time_slice[1]
+	//add the event to the calendar
+	evt := &calendar.Event{Summary: parsed.Name, Start: start, End: end, Recurrence: parsed.Recurrence, Attendees: attendees}
+	attribute(message, evt)
+	created, err := backend.Insert(calendarID, evt, sendUpdates)
+	if err != nil {
+		return err
+	}
+	handles, err := issueRefs(message.ChatID, calendarID, []*calendar.Event{created})
+	if err != nil {
+		return err
+	}
+	added := "hinzugefügt" + byMember(message)
+	if len(guests) > 0 {
+		added = "mit " + strings.Join(guests, ", ") + " " + added
+	}
+	if calName != "" {
+		added = "zu " + calName + " " + added
+	}
+	if rule, ok := parseRRule(created.Recurrence); ok {
+		message.Replyf("Serie %v (%v, %v) %v [%v]\nDie einzelnen Termine zeigt /instances %v",
+			parsed.Name, formatParsedTime(parsed.Start, parsed.End, parsed.AllDay), rule.describe(loc), added, handles[0], handles[0])
+		return nil
+	}
+	reply := fmt.Sprintf("Termin %v (%v) %v [%v]", parsed.Name, formatParsedTime(parsed.Start, parsed.End, parsed.AllDay), added, handles[0])
+	message.Reply(reply)
+	return nil
+}
 
-	//remove date and time from the user input. So we only have the name of the event left
-	//additionally trim trailing and leading spaces from the name
-	name := strings.Trim(strings.Replace(strings.Replace(user_input, compl_time, "", -1), date, "", -1), " ")
+// DeleteTaskHandler deletes the event behind a handle from /show.
+// "/delete k3f9" asks for confirmation, "/delete k3f9 ja" deletes the
+// event unless it changed since it was listed. Of a series only the one
+// event is deleted, "/delete k3f9 serie ja" deletes the whole series.
+func DeleteTaskHandler(message *tbot.Message) error {
+	args := strings.Fields(message.Vars["eventstring"])
+	if len(args) == 0 || len(args) > 3 {
+		return parseError("Bitte gib den Termin mit seinem Kürzel aus /show an, z.B. /delete k3f9.")
+	}
+	series := len(args) > 1 && isSeriesWord(args[1])
+	confirmed := len(args) > 1 && isConfirmation(args[len(args)-1])
 
-	//adjust time formats to RFC3339 since the calendar API expects it in that format
-	parsed_stime, _ := time.Parse("15:04 02/01/2006", stime+" "+date)
-	parsed_etime, _ := time.Parse("15:04 02/01/2006", etime+" "+date)
-	formatted_stime := parsed_stime.Format(time.RFC3339)
-	formatted_etime := parsed_etime.Format(time.RFC3339)
+	ref, err := resolveRef(message.ChatID, args[0])
+	if err != nil {
+		return err
+	}
+	backend, calendarID, err := chatBackend(message.ChatID, message.From.ID)
+	if err != nil {
+		return err
+	}
+	evt, err := currentEvent(backend, ref)
+	if err != nil {
+		return err
+	}
+	//the handle of a series added with /add stands for the whole series
+	series = series || ref.EventID == ref.SeriesID
+	if series {
+		if ref, evt, err = seriesEvent(backend, ref); err != nil {
+			return err
+		}
+	}
 
-	//adjust Time entries from UTC to UTC+1
-	start := &calendar.EventDateTime{
-		DateTime: strings.Replace(formatted_stime, "Z", "", len(formatted_stime)) + "+01:00",
-		TimeZone: "Europe/Berlin",
+	if !confirmed {
+		loc, err := userLocation(message.From.ID, backend, calendarID)
+		if err != nil {
+			return err
+		}
+		handle := strings.ToLower(args[0])
+		switch {
+		case series:
+			message.Replyf("Soll ich die ganze Serie %v (%v) wirklich löschen? Dann sende /delete %v serie ja",
+				evt.Summary, formatSeriesTime(evt, loc), handle)
+		case ref.SeriesID != "":
+			message.Replyf("Soll ich den Termin %v (%v) wirklich löschen? Dann sende /delete %v ja\n"+
+				"Die übrigen Termine der Serie bleiben erhalten, die ganze Serie löscht /delete %v serie",
+				evt.Summary, formatEventTime(evt, loc), handle, handle)
+		default:
+			message.Replyf("Soll ich den Termin %v (%v) wirklich löschen? Dann sende /delete %v ja",
+				evt.Summary, formatEventTime(evt, loc), handle)
+		}
+		return nil
 	}
-	end := &calendar.EventDateTime{
-		DateTime: strings.Replace(formatted_etime, "Z", "", len(formatted_stime)) + "+01:00",
-		TimeZone: "Europe/Berlin",
+
+	if err := backend.Delete(ref.CalendarID, ref.EventID, guestUpdates(evt)); err != nil {
+		return err
 	}
 
-	//add the event to the calendar
-	evt := &calendar.Event{Summary: name, Start: start, End: end}
-	_, err := srv.Events.Insert(calendarId, evt).Do()
-	checkError(err)
-	reply := fmt.Sprintf("Termin %v (%v %v) hinzugefügt", name, date, compl_time)
+	reply := fmt.Sprintf("Termin %v gelöscht%v", evt.Summary, byMember(message))
+	if series {
+		reply = fmt.Sprintf("Serie %v gelöscht%v", evt.Summary, byMember(message))
+	}
 	message.Reply(reply)
+	return nil
 }
 
-func DeleteTaskHandler(message *tbot.Message) {
-	t := time.Now().Format(time.RFC3339)
-	events, err := srv.Events.List(calendarId).ShowDeleted(false).SingleEvents(true).TimeMin(t).MaxResults(200).OrderBy("startTime").Do()
-	checkError(err)
-
-	deleteNumber, err := strconv.Atoi(message.Vars["eventstring"])
-	checkError(err)
-	eventId := events.Items[deleteNumber-1].Id
-	event_name := events.Items[deleteNumber-1].Summary
+// isConfirmation reports whether word confirms a question.
+func isConfirmation(word string) bool {
+	switch strings.ToLower(word) {
+	case "ja", "j", "yes", "y", "ok":
+		return true
+	}
+	return false
+}
 
-	srv.Events.Delete(calendarId, eventId).Do()
+const (
+	// showMax is the most events /show sends, each as own message with buttons.
+	showMax = 10
+	// showPeriodMax is the most events /show lists for a period.
+	showPeriodMax = 100
 
-	reply := fmt.Sprintf("Termin %v gelöscht", event_name)
-	message.Reply(reply)
-}
+	showUsage = "Bitte gib an, wie viele Termine ich zeigen soll, z.B. /show 5, oder welchen Zeitraum, " +
+		"z.B. /show heute, /show morgen, /show woche, /show nächsten monat, /show 12/03/2025 oder /show 01/03-15/03."
+)
 
-func ShowTasksHandler(message *tbot.Message) {
+func ShowTasksHandler(message *tbot.Message) error {
 	var number_results int64
 	var err error
 
-	if message.Vars["number"] == "" {
-		number_results = 100
-	} else {
-		number_results, err = strconv.ParseInt(message.Vars["number"], 10, 64)
-		checkError(err)
+	number, calName := takeCalendar(strings.TrimSpace(message.Vars["number"]))
+	backend, calendarID, err := chatBackend(message.ChatID, message.From.ID)
+	if err != nil {
+		return err
+	}
+	calendarID, calName, err = targetCalendar(message.ChatID, backend, calendarID, calName)
+	if err != nil {
+		return err
+	}
+	loc, err := userLocation(message.From.ID, backend, calendarID)
+	if err != nil {
+		return err
+	}
+	in := ""
+	if calName != "" {
+		in = " in " + calName
 	}
 
-	t := time.Now().Format(time.RFC3339)
-	events, err := srv.Events.List(calendarId).ShowDeleted(false).SingleEvents(true).TimeMin(t).MaxResults(number_results).OrderBy("startTime").Do()
-	checkError(err)
-	var formattedEvents string
-
-	if len(events.Items) == 0 {
-		message.Reply("Keine anstehenden Termine.")
-	} else {
-		formattedEvents += "Die nächsten " + strconv.FormatInt(number_results, 10) + " Termine: \n\n"
-		for i, item := range events.Items {
-			date := item.Start.DateTime
-			parsed_time, _ := time.Parse(time.RFC3339, date)
-
-			end_date := item.End.DateTime
-			parsed_end_date, _ := time.Parse(time.RFC3339, end_date)
-			formatted_end_date := parsed_end_date.Format("15:04")
-
-			if date == "" {
-				date = item.Start.Date
-				parsed_time, _ = time.Parse("2006-01-02", date)
-				formatted_end_date = "00:00"
-			}
-
-			formatted_date := parsed_time.Format("02/01/2006 15:04")
-
-			event_string := fmt.Sprintf("%v (%v-%v)\n", item.Summary, formatted_date, formatted_end_date)
-			formattedEvents += "[" + strconv.Itoa(i+1) + "] " + event_string
+	//every event is sent as its own message with buttons, so only a few
+	if number == "" {
+		number_results = showMax
+	} else if number_results, err = strconv.ParseInt(number, 10, 64); err != nil {
+		//anything but a number is a period like "today" or "next week"
+		period, err := parser.ParsePeriod(number, time.Now().In(loc))
+		if err != nil {
+			return parseError(showUsage)
 		}
-		message.Reply(formattedEvents)
+		return showPeriod(message, backend, calendarID, in, period, loc)
+	}
+	if number_results < 1 {
+		return parseError(showUsage)
+	}
+	more := ""
+	if number_results > showMax {
+		number_results = showMax
+		more = " (mehr zeigt ein Zeitraum wie /show woche)"
 	}
-}
 
-func TodoHandler(message *tbot.Message) {
+	events, err := backend.Upcoming(calendarID, time.Now(), number_results)
+	if err != nil {
+		return err
+	}
+	handles, err := issueRefs(message.ChatID, calendarID, events)
+	if err != nil {
+		return err
+	}
 
+	if len(events) == 0 {
+		message.Reply("Keine anstehenden Termine" + in + ".")
+		return nil
+	}
+	message.Reply("Die nächsten " + strconv.Itoa(len(events)) + " Termine" + in + more + ":")
+	replyEvents(message, events, handles, loc)
+	return nil
 }
 
-func getGoogleClient() *http.Client {
-	clientid := os.Getenv("CLIENTID")
-	clientsecret := os.Getenv("CLIENTSECRET")
-	redirecturl := os.Getenv("REDIRECTURL")
-
-	conf := &oauth2.Config{
-		ClientID:     clientid,
-		ClientSecret: clientsecret,
-		RedirectURL:  redirecturl,
-		Scopes: []string{
-			"https://www.googleapis.com/auth/calendar",
-		},
-		Endpoint: google.Endpoint,
-	}
-
-	authURL := conf.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	log.Println("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-	authCode := os.Getenv("AUTHCODE")
-	tok, err := conf.Exchange(context.TODO(), authCode)
-	checkError(err)
-
-	client := conf.Client(context.Background(), tok)
+// showPeriod lists the events of a period grouped by day for /show with a
+// period, e.g. "/show today" or "/show 01/03-15/03".
+func showPeriod(message *tbot.Message, backend CalendarBackend, calendarID, in string, period *parser.Period, loc *time.Location) error {
+	events, err := backend.Between(calendarID, period.Start, period.End, showPeriodMax)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		message.Reply("Keine Termine " + formatPeriod(period) + in + ".")
+		return nil
+	}
+	handles, err := issueRefs(message.ChatID, calendarID, events)
+	if err != nil {
+		return err
+	}
+	reply := "Termine " + formatPeriod(period) + in + ":\n" + formatAgenda(events, handles, period.Start, loc)
+	if len(events) == showPeriodMax {
+		reply += fmt.Sprintf("\n\nDas sind nur die ersten %v Termine, weitere zeigt ein kürzerer Zeitraum.", showPeriodMax)
+	}
+	message.Reply(reply)
+	return nil
+}
 
-	return client
+// replyEvents sends each event with its handle and buttons as own message.
+func replyEvents(message *tbot.Message, events []*calendar.Event, handles []string, loc *time.Location) {
+	for i, item := range events {
+		event_string := fmt.Sprintf("[%v] %v (%v)", handles[i], item.Summary, formatEventTime(item, loc))
+		message.ReplyInlineKeyboard(event_string, eventButtons(handles[i]), tbot.WithDataInlineButtons)
+	}
 }
 
+// checkError stops the bot on errors it cannot start without.
 func checkError(err error) {
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yanzay/tbot"
+	"github.com/yanzay/tbot/model"
+)
+
+// testUser and testChat send the messages of the handler tests, a private chat has the ID of the user.
+const (
+	testUser = 42
+	testChat = int64(testUser)
+)
+
+// setupHandlers runs the handlers on the memory backend and a fresh store.
+func setupHandlers(t *testing.T) {
+	memory = newMemoryBackend()
+	store = newMemoryStore()
+	todos = newTodoStore(store)
+	calendarId = "primary"
+	t.Cleanup(func() {
+		memory, store, todos, calendarId = nil, nil, nil, ""
+	})
+}
+
+// send runs a handler like the bot does for a message of userID in chatID
+// and returns the text of the replies.
+func send(h func(*tbot.Message) error, chatID int64, userID int, vars tbot.MessageVars) []string {
+	replies := make(chan *model.Message, 200)
+	message := &tbot.Message{
+		Message: &model.Message{ChatID: chatID, From: model.User{ID: userID, UserName: "tester"}},
+		Vars:    vars,
+	}
+	message.SetReplyChannel(replies)
+	handle(h)(message)
+	close(replies)
+
+	var texts []string
+	for reply := range replies {
+		texts = append(texts, reply.Data)
+	}
+	return texts
+}
+
+// sendOne is send for handlers that answer with a single message.
+func sendOne(t *testing.T, h func(*tbot.Message) error, vars tbot.MessageVars) string {
+	t.Helper()
+	replies := send(h, testChat, testUser, vars)
+	if len(replies) != 1 {
+		t.Fatalf("got %v replies to %v, want 1: %q", len(replies), vars, replies)
+	}
+	return replies[0]
+}
+
+var handleInReply = regexp.MustCompile(`\[(\w+)\]`)
+
+// replyHandle returns the handle of the event in a reply like "Termin Standup (...) hinzugefügt [k3f9]".
+func replyHandle(t *testing.T, reply string) string {
+	t.Helper()
+	m := handleInReply.FindStringSubmatch(reply)
+	if m == nil {
+		t.Fatalf("no handle in %q", reply)
+	}
+	return m[1]
+}
+
+func wantContains(t *testing.T, reply string, parts ...string) {
+	t.Helper()
+	for _, part := range parts {
+		if !strings.Contains(reply, part) {
+			t.Errorf("reply %q does not contain %q", reply, part)
+		}
+	}
+}
+
+func TestAddShowDelete(t *testing.T) {
+	setupHandlers(t)
+
+	reply := sendOne(t, CreateTaskHandler, tbot.MessageVars{"eventstring": "Standup übermorgen 10-11"})
+	wantContains(t, reply, "Termin Standup (", "10:00-11:00) hinzugefügt")
+	handle := replyHandle(t, reply)
+	sendOne(t, CreateTaskHandler, tbot.MessageVars{"eventstring": "Urlaub in 5 Tagen"})
+
+	replies := send(ShowTasksHandler, testChat, testUser, tbot.MessageVars{})
+	if len(replies) != 3 {
+		t.Fatalf("/show replied %q, want a header and 2 events", replies)
+	}
+	wantContains(t, replies[0], "Die nächsten 2 Termine")
+	wantContains(t, replies[1], "["+handle+"] Standup")
+	wantContains(t, replies[2], "Urlaub")
+
+	reply = sendOne(t, DeleteTaskHandler, tbot.MessageVars{"eventstring": handle})
+	wantContains(t, reply, "wirklich löschen", "/delete "+handle+" ja")
+	reply = sendOne(t, DeleteTaskHandler, tbot.MessageVars{"eventstring": handle + " ja"})
+	wantContains(t, reply, "Termin Standup gelöscht")
+
+	replies = send(ShowTasksHandler, testChat, testUser, tbot.MessageVars{})
+	if len(replies) != 2 {
+		t.Fatalf("/show after /delete replied %q, want a header and 1 event", replies)
+	}
+	reply = sendOne(t, DeleteTaskHandler, tbot.MessageVars{"eventstring": handle + " ja"})
+	wantContains(t, reply, "gibt es nicht")
+}
+
+func TestAddErrors(t *testing.T) {
+	setupHandlers(t)
+
+	tests := []struct {
+		eventstring string
+		want        string
+	}{
+		{"Standup", "Bitte gib an, wann der Termin ist"},
+		{"morgen 10-11", "Bitte gib einen Namen für den Termin an"},
+		{"Standup morgen 10 in:Privat", "Privat"},
+	}
+	for _, tt := range tests {
+		reply := sendOne(t, CreateTaskHandler, tbot.MessageVars{"eventstring": tt.eventstring})
+		wantContains(t, reply, tt.want)
+	}
+	if events, _ := memory.Upcoming("primary", time.Now(), 10); len(events) != 0 {
+		t.Errorf("failed /add created %v events", len(events))
+	}
+}
+
+func TestShowPeriod(t *testing.T) {
+	setupHandlers(t)
+
+	sendOne(t, CreateTaskHandler, tbot.MessageVars{"eventstring": "Review übermorgen 14:00"})
+	sendOne(t, CreateTaskHandler, tbot.MessageVars{"eventstring": "Planung übermorgen 9-10 in:Team"})
+
+	reply := sendOne(t, ShowTasksHandler, tbot.MessageVars{"number": "übermorgen"})
+	wantContains(t, reply, "14:00-15:00", "Review")
+	if strings.Contains(reply, "Planung") {
+		t.Errorf("/show übermorgen lists an event of another calendar: %q", reply)
+	}
+	reply = sendOne(t, ShowTasksHandler, tbot.MessageVars{"number": "übermorgen in:Team"})
+	wantContains(t, reply, "in Team", "09:00-10:00", "Planung")
+
+	reply = sendOne(t, ShowTasksHandler, tbot.MessageVars{"number": "01/01/2020"})
+	wantContains(t, reply, "Keine Termine")
+}
+
+func TestSearch(t *testing.T) {
+	setupHandlers(t)
+
+	sendOne(t, CreateTaskHandler, tbot.MessageVars{"eventstring": "Zahnarzt übermorgen 14:00"})
+	sendOne(t, CreateTaskHandler, tbot.MessageVars{"eventstring": "Planung übermorgen 9-10"})
+
+	reply := sendOne(t, SearchHandler, tbot.MessageVars{"text": "zahnarzt"})
+	wantContains(t, reply, "Termine mit \"zahnarzt\"", "Zahnarzt")
+	if strings.Contains(reply, "Planung") {
+		t.Errorf("/search zahnarzt lists Planung: %q", reply)
+	}
+	reply = sendOne(t, SearchHandler, tbot.MessageVars{"text": "Friseur"})
+	wantContains(t, reply, "Keine Termine mit \"Friseur\"")
+}
+
+func TestConnectOnlyInPrivate(t *testing.T) {
+	setupHandlers(t)
+
+	for _, vars := range []tbot.MessageVars{{}, {"code": "4/0Ab-secret"}} {
+		replies := send(ConnectHandler, -100, testUser, vars)
+		if len(replies) != 1 || !strings.Contains(replies[0], "nur im privaten Chat") {
+			t.Errorf("/connect %v in a group replied %q", vars, replies)
+		}
+	}
+}
+
+func TestShowLimit(t *testing.T) {
+	setupHandlers(t)
+
+	for i := 1; i <= showMax+2; i++ {
+		sendOne(t, CreateTaskHandler, tbot.MessageVars{"eventstring": fmt.Sprintf("Termin%v in %v Tagen 10:00", i, i)})
+	}
+	replies := send(ShowTasksHandler, testChat, testUser, tbot.MessageVars{"number": "100"})
+	if len(replies) != showMax+1 {
+		t.Fatalf("/show 100 sent %v messages, want %v", len(replies), showMax+1)
+	}
+	wantContains(t, replies[0], "Die nächsten 10 Termine (mehr zeigt")
+}
+
+func TestBoundGroupCalendar(t *testing.T) {
+	setupHandlers(t)
+	const chat, member = int64(-100), 7
+	if err := store.Put(groupsBucket, "-100", &group{ChatID: chat, CalendarID: "team", CalendarName: "Team", OwnerID: testUser}); err != nil {
+		t.Fatal(err)
+	}
+
+	replies := send(CreateTaskHandler, chat, member, tbot.MessageVars{"eventstring": "Standup übermorgen 10-11 in:Kalender"})
+	if len(replies) != 1 || !strings.Contains(replies[0], "nur den Kalender nutzen, an den sie gebunden ist") {
+		t.Errorf("/add in:Kalender in a bound group replied %q", replies)
+	}
+	for _, name := range []string{"Kalender", "Privat"} {
+		replies = send(ShowTasksHandler, chat, member, tbot.MessageVars{"number": "in:" + name})
+		if len(replies) != 1 || !strings.Contains(replies[0], "an den sie gebunden ist") {
+			t.Errorf("/show in:%v in a bound group replied %q", name, replies)
+		}
+	}
+	replies = send(CreateTaskHandler, chat, member, tbot.MessageVars{"eventstring": "Standup übermorgen 10-11 in:Team"})
+	if len(replies) != 1 || !strings.Contains(replies[0], "hinzugefügt") {
+		t.Errorf("/add in:Team in a group bound to Team replied %q", replies)
+	}
+	if events, _ := memory.Upcoming("primary", time.Now(), 10); len(events) != 0 {
+		t.Errorf("the group added %v events to the owner's own calendar", len(events))
+	}
+}
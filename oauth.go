@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yanzay/tbot"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// pendingLinkTTL is how long a /connect link stays valid.
+const pendingLinkTTL = 15 * time.Minute
+
+// pendingLink is an authorization started with /connect that waits for its code.
+type pendingLink struct {
+	userID  int
+	chatID  int64
+	created time.Time
+}
+
+var (
+	oauthConfig *oauth2.Config
+
+	linksMu      sync.Mutex
+	pendingLinks = make(map[string]pendingLink) // oauth state -> link
+)
+
+// newOAuthConfig builds the Google OAuth configuration from the environment.
+func newOAuthConfig() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     os.Getenv("CLIENTID"),
+		ClientSecret: os.Getenv("CLIENTSECRET"),
+		RedirectURL:  os.Getenv("REDIRECTURL"),
+		Scopes: []string{
+			"https://www.googleapis.com/auth/calendar",
+		},
+		Endpoint: google.Endpoint,
+	}
+}
+
+// ConnectHandler sends the user a personal authorization link for /connect
+// and exchanges the code for /connect {code}. Both only work in the private
+// chat, in a group anybody could link their account to the user.
+func ConnectHandler(message *tbot.Message) error {
+	if isGroupChat(message) {
+		return parseError("Den Link zu deinem Kalender schicke ich dir nur im privaten Chat, bitte schreib mir dort /connect.")
+	}
+	if memory != nil {
+		message.Reply("Der Bot läuft ohne Google Kalender, eine Verbindung ist nicht nötig.")
+		return nil
+	}
+
+	code := message.Vars["code"]
+	if code == "" {
+		state, err := newLinkState(message.From.ID, message.ChatID)
+		if err != nil {
+			return err
+		}
+		authURL := oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+		message.Reply("Öffne den folgenden Link und erlaube den Zugriff auf deinen Kalender. "+
+			"Falls du danach einen Code angezeigt bekommst, schicke ihn mir mit /connect <code>.\n\n"+authURL,
+			tbot.DisablePreview)
+		return nil
+	}
+
+	if err := linkAccount(message.From.ID, message.ChatID, code); err != nil {
+		return &botError{kind: kindAuth, msg: "Der Code ist ungültig oder abgelaufen. Hole dir mit /connect einen neuen Link.", err: err}
+	}
+	message.Reply("Dein Google Kalender ist jetzt verbunden.")
+	return nil
+}
+
+// newLinkState creates a random oauth state remembering who asked for it.
+func newLinkState(userID int, chatID int64) (string, error) {
+	state, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+
+	linksMu.Lock()
+	defer linksMu.Unlock()
+	for s, link := range pendingLinks {
+		if time.Since(link.created) > pendingLinkTTL {
+			delete(pendingLinks, s)
+		}
+	}
+	pendingLinks[state] = pendingLink{userID: userID, chatID: chatID, created: time.Now()}
+	return state, nil
+}
+
+// takeLinkState returns and forgets the pending link for state.
+func takeLinkState(state string) (pendingLink, bool) {
+	linksMu.Lock()
+	defer linksMu.Unlock()
+	link, ok := pendingLinks[state]
+	delete(pendingLinks, state)
+	if !ok || time.Since(link.created) > pendingLinkTTL {
+		return pendingLink{}, false
+	}
+	return link, true
+}
+
+// linkAccount exchanges an authorization code and stores the token for the user.
+func linkAccount(userID int, chatID int64, code string) error {
+	tok, err := oauthConfig.Exchange(context.Background(), code)
+	if err != nil {
+		return err
+	}
+	return updateUser(userID, func(u *user) {
+		if chatID > 0 {
+			u.ChatID = chatID
+		}
+		u.Token = tok
+		//the account may be another one than before
+		u.Email = ""
+	})
+}
+
+// oauthCallbackHandler receives the redirect from Google after the user
+// allowed access and finishes the link started with /connect.
+func oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	link, ok := takeLinkState(r.FormValue("state"))
+	if !ok {
+		http.Error(w, "Unbekannter oder abgelaufener Link, bitte /connect erneut senden.", http.StatusBadRequest)
+		return
+	}
+	if err := linkAccount(link.userID, link.chatID, r.FormValue("code")); err != nil {
+		log.Printf("Error exchanging code of user %v: %v", link.userID, err)
+		http.Error(w, "Die Anmeldung ist fehlgeschlagen, bitte /connect erneut senden.", http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintln(w, "Dein Google Kalender ist verbunden, du kannst zurück zu Telegram wechseln.")
+	if err := bot.Send(link.chatID, "Dein Google Kalender ist jetzt verbunden."); err != nil {
+		log.Printf("Error sending message: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// isoDateExpr matches dates like 2025-03-12
+	isoDateExpr = regexp.MustCompile(`^(\d{4})-(\d{1,2})-(\d{1,2})$`)
+	// dateExpr matches dates like 12/03/2025, 12.03.2025, 12/03/25, 12/03 and 12.03.
+	dateExpr = regexp.MustCompile(`^(\d{1,2})([./])(\d{1,2})(?:[./](\d{4}|\d{2})?)?$`)
+	// amountUnitExpr matches amounts with a unit in one word like 30min or 2h
+	amountUnitExpr = regexp.MustCompile(`^(\d+)([a-zäöü]+)$`)
+)
+
+// dateWords recognizes a date or a range of days at i, optionally
+// preceded by "on"/"am" or, for weekdays, by "next"/"nächsten" and the like.
+func (p *parser) dateWords(i int) int {
+	n := 0
+	if datePrefixes[p.word(i)] || weekdayPrefixes[p.word(i)] {
+		n = 1
+	}
+	if n == 1 && !datePrefixes[p.word(i)] {
+		if _, ok := weekdays[p.word(i+1)]; !ok {
+			return 0
+		}
+	}
+
+	if first, last, ok := parseDateRange(p.raw(i+n), p.now); ok {
+		p.setDate(first)
+		p.setLastDate(last)
+		return n + 1
+	}
+
+	first, words := p.dateAt(i + n)
+	if words == 0 {
+		return 0
+	}
+	p.setDate(first)
+	n += words
+
+	if dateSeparators[p.word(i+n)] {
+		if last, words := p.dateAt(i + n + 1); words > 0 {
+			if last.Before(first) {
+				last = last.AddDate(1, 0, 0)
+			}
+			p.setLastDate(last)
+			n += 1 + words
+		}
+	}
+	return n
+}
+
+// dateAt recognizes a single date at i and returns it with the number of words used.
+func (p *parser) dateAt(i int) (time.Time, int) {
+	if p.word(i) == "day" && p.word(i+1) == "after" && p.word(i+2) == "tomorrow" {
+		return midnight(p.now).AddDate(0, 0, 2), 3
+	}
+	if offset, ok := relativeDays[p.word(i)]; ok {
+		return midnight(p.now).AddDate(0, 0, offset), 1
+	}
+	if wd, ok := weekdays[p.word(i)]; ok {
+		return nextWeekday(p.now, wd), 1
+	}
+	if d, ok := parseDate(p.raw(i), p.now); ok {
+		return d, 1
+	}
+	return time.Time{}, 0
+}
+
+func (p *parser) setDate(d time.Time) {
+	p.date = d
+	p.hasDate = true
+}
+
+func (p *parser) setLastDate(d time.Time) {
+	if d.After(p.date) {
+		p.lastDate = d
+		p.hasLastDate = true
+	}
+}
+
+// relative recognizes "in 2 hours", "in 30min" or "in 3 Tagen" at i.
+// Amounts of minutes and hours set the start time, days and weeks the date.
+func (p *parser) relative(i int) int {
+	if p.word(i) != "in" {
+		return 0
+	}
+	n, unit, words := p.amountWithUnit(i + 1)
+	if words == 0 {
+		return 0
+	}
+	if d, ok := clockUnits[unit]; ok {
+		p.relStart = p.now.Add(time.Duration(n) * d).Truncate(time.Minute)
+		return 1 + words
+	}
+	if days, ok := dayUnits[unit]; ok {
+		p.setDate(midnight(p.now).AddDate(0, 0, n*days))
+		return 1 + words
+	}
+	return 0
+}
+
+// amountWithUnit recognizes an amount and its unit at i, either in one
+// word like "30min" or in two like "30 minutes". It returns the number
+// of words used, 0 if there is no amount.
+func (p *parser) amountWithUnit(i int) (n int, unit string, words int) {
+	if m := amountUnitExpr.FindStringSubmatch(p.word(i)); m != nil {
+		n, _ = strconv.Atoi(m[1])
+		return n, m[2], 1
+	}
+	n, ok := amount(p.word(i))
+	if !ok || p.word(i+1) == "" {
+		return 0, "", 0
+	}
+	return n, p.word(i + 1), 2
+}
+
+// parseDate parses a numeric date. Dates without a year lie in the future.
+func parseDate(word string, now time.Time) (time.Time, bool) {
+	var year, month, day int
+	if m := isoDateExpr.FindStringSubmatch(word); m != nil {
+		year, _ = strconv.Atoi(m[1])
+		month, _ = strconv.Atoi(m[2])
+		day, _ = strconv.Atoi(m[3])
+		return validDate(year, month, day, now.Location())
+	}
+
+	m := dateExpr.FindStringSubmatch(word)
+	if m == nil {
+		return time.Time{}, false
+	}
+	// 14.30 is rather a time than a date, dates with dots need a trailing dot or a year
+	if m[2] == "." && !strings.HasSuffix(word, ".") && m[4] == "" {
+		return time.Time{}, false
+	}
+	day, _ = strconv.Atoi(m[1])
+	month, _ = strconv.Atoi(m[3])
+	switch {
+	case len(m[4]) == 4:
+		year, _ = strconv.Atoi(m[4])
+	case len(m[4]) == 2:
+		year, _ = strconv.Atoi(m[4])
+		year += 2000
+	default:
+		year = now.Year()
+		if d, ok := validDate(year, month, day, now.Location()); ok && d.Before(midnight(now)) {
+			year++
+		}
+	}
+	return validDate(year, month, day, now.Location())
+}
+
+// parseDateRange parses a range of days written as one word like 12/03/2025-15/03/2025.
+func parseDateRange(word string, now time.Time) (first, last time.Time, ok bool) {
+	word = strings.Replace(word, "–", "-", -1)
+	for i, r := range word {
+		if r != '-' {
+			continue
+		}
+		first, ok1 := parseDate(word[:i], now)
+		last, ok2 := parseDate(word[i+1:], now)
+		if ok1 && ok2 {
+			//a range under way, like 01/03-15/03 on 12/03, started this year
+			if earlier, _ := parseDate(word[:i], first.AddDate(-1, 0, 0)); last.Before(first) && earlier.Before(first) && !last.Before(earlier) {
+				first = earlier
+			}
+			if last.Before(first) {
+				last = last.AddDate(1, 0, 0)
+			}
+			return first, last, true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// validDate returns midnight of the given day if it exists.
+func validDate(year, month, day int, loc *time.Location) (time.Time, bool) {
+	d := time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)
+	if d.Year() != year || int(d.Month()) != month || d.Day() != day {
+		return time.Time{}, false
+	}
+	return d, true
+}
+
+// nextWeekday returns the next day after now that falls on wd.
+func nextWeekday(now time.Time, wd time.Weekday) time.Time {
+	days := (int(wd) - int(now.Weekday()) + 7) % 7
+	if days == 0 {
+		days = 7
+	}
+	return midnight(now).AddDate(0, 0, days)
+}
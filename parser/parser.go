@@ -0,0 +1,263 @@
+// Package parser understands the dates and times users write when they
+// add events, in English and German, e.g. "Standup tomorrow 10-12",
+// "Review next friday 9am" or "Zahnarzt am Montag um 14 Uhr".
+package parser
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// DefaultDuration is the length of events that only have a start time.
+const DefaultDuration = time.Hour
+
+var (
+	// ErrNoDate is returned if the input contains neither a date nor a time.
+	ErrNoDate = errors.New("parser: no date or time found")
+	// ErrNoName is returned if nothing is left for the event name.
+	ErrNoName = errors.New("parser: no event name found")
+	// ErrNoDuration is returned if the input is not a length of time.
+	ErrNoDuration = errors.New("parser: no duration found")
+)
+
+// Event is an event parsed from user input.
+// All-day events start at midnight of their first day and end at
+// midnight after their last day.
+type Event struct {
+	Name       string
+	Start      time.Time
+	End        time.Time
+	AllDay     bool
+	DefaultEnd bool     // the input had no end, End is Start plus DefaultDuration
+	TimeOnly   bool     // the input had a time of day but no date, e.g. "15:00"
+	Recurrence []string // RRULE lines if the event repeats, e.g. "every monday"
+}
+
+// Parse extracts the name, start and end of an event from input.
+// Relative dates like "tomorrow" are resolved against now, and all
+// times are interpreted in the location of now.
+func Parse(input string, now time.Time) (*Event, error) {
+	evt, err := ParseTime(input, now)
+	if err == nil && evt.Name == "" {
+		return nil, ErrNoName
+	}
+	return evt, err
+}
+
+// ParseTime is like Parse, but the input does not need to contain a name,
+// e.g. "tomorrow 10-12".
+func ParseTime(input string, now time.Time) (*Event, error) {
+	p := newParser(input, now)
+	p.scan()
+	// only a time without any date may be moved to tomorrow
+	undated := !p.hasDate && p.relStart.IsZero() && p.rec.first.IsZero()
+
+	// a repeating event without a date starts on its first day
+	if p.rec.freq != "" && !p.hasDate && p.relStart.IsZero() {
+		if p.rec.first.IsZero() {
+			p.setDate(midnight(p.now))
+		} else {
+			p.setDate(p.rec.first)
+		}
+	}
+
+	var start time.Time
+	switch {
+	case !p.relStart.IsZero():
+		start = p.relStart
+	case p.hasStart:
+		start = p.at(p.day(), p.start)
+		// a time that has already passed today is meant for tomorrow
+		if undated && start.Before(p.now) {
+			p.setDate(p.day().AddDate(0, 0, 1))
+			start = p.at(p.day(), p.start)
+		}
+	case p.hasDate:
+		return p.withRecurrence(p.allDay()), nil
+	default:
+		return nil, ErrNoDate
+	}
+
+	// a timed event over several days ends on the last day
+	// at the time it would end on the first one
+	last := start
+	if p.hasLastDate {
+		last = p.at(p.lastDate, p.start)
+	}
+
+	var end time.Time
+	switch {
+	case p.hasEnd:
+		end = p.at(last, p.end)
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+	case p.days > 0:
+		end = start.AddDate(0, 0, p.days)
+	case p.duration > 0:
+		end = last.Add(p.duration)
+	default:
+		end = last.Add(DefaultDuration)
+	}
+
+	defaultEnd := !p.hasEnd && p.days == 0 && p.duration == 0
+	timeOnly := undated && p.rec.freq == ""
+	return p.withRecurrence(&Event{Name: p.name(), Start: start, End: end, DefaultEnd: defaultEnd, TimeOnly: timeOnly}), nil
+}
+
+// withRecurrence adds the recurrence found in the input to evt.
+func (p *parser) withRecurrence(evt *Event) *Event {
+	if p.rec.freq != "" {
+		evt.Recurrence = []string{p.rec.rule(evt.AllDay)}
+	}
+	return evt
+}
+
+// ParseDuration parses a length of time like "90min", "2 hours",
+// "1h 30min" or "3 Tage". Days are counted as 24 hours.
+func ParseDuration(input string) (time.Duration, error) {
+	p := newParser(input, time.Time{})
+	var total time.Duration
+	for i := 0; i < len(p.words); {
+		if p.word(i) == "and" || p.word(i) == "und" {
+			i++
+			continue
+		}
+		n, unit, words := p.amountWithUnit(i)
+		if d, ok := clockUnits[unit]; ok && words > 0 {
+			total += time.Duration(n) * d
+		} else if days, ok := dayUnits[unit]; ok && words > 0 {
+			total += time.Duration(n*days) * 24 * time.Hour
+		} else {
+			return 0, ErrNoDuration
+		}
+		i += words
+	}
+	if total <= 0 {
+		return 0, ErrNoDuration
+	}
+	return total, nil
+}
+
+// allDay returns the all-day event for a date without a time of day.
+func (p *parser) allDay() *Event {
+	end := p.date.AddDate(0, 0, 1)
+	switch {
+	case p.hasLastDate:
+		end = p.lastDate.AddDate(0, 0, 1)
+	case p.days > 0:
+		end = p.date.AddDate(0, 0, p.days)
+	}
+	return &Event{Name: p.name(), Start: p.date, End: end, AllDay: true}
+}
+
+// parser holds the state of parsing one input.
+type parser struct {
+	now   time.Time
+	words []string // input split at white space
+	lower []string // normalized words
+	used  []bool   // words consumed by a date or time expression
+
+	date        time.Time // midnight of the given day
+	hasDate     bool
+	lastDate    time.Time // midnight of the last day of a range of days
+	hasLastDate bool
+	start       clock
+	hasStart    bool
+	end         clock
+	hasEnd      bool
+	relStart    time.Time     // start given relative to now, e.g. "in 2 hours"
+	duration    time.Duration // e.g. "for 30min"
+	days        int           // e.g. "for 3 days"
+	rec         recurrence    // e.g. "every monday"
+}
+
+func newParser(input string, now time.Time) *parser {
+	p := &parser{now: now, words: strings.Fields(input)}
+	p.lower = make([]string, len(p.words))
+	p.used = make([]bool, len(p.words))
+	for i, w := range p.words {
+		p.lower[i] = normalize(w)
+	}
+	return p
+}
+
+// normalize lowercases a word and strips punctuation that does not
+// belong to dates or times.
+func normalize(word string) string {
+	w := strings.ToLower(strings.Trim(word, ",;!?()\"'"))
+	w = strings.Replace(w, "a.m.", "am", 1)
+	w = strings.Replace(w, "p.m.", "pm", 1)
+	return w
+}
+
+// scan runs all recognizers over the words and marks what they consumed.
+func (p *parser) scan() {
+	recognizers := []func(int) int{
+		p.recurrence,
+		p.recurrenceEnd,
+		p.relative,
+		p.forDuration,
+		p.dateWords,
+		p.times,
+	}
+	for i := 0; i < len(p.words); {
+		n := 0
+		for _, recognize := range recognizers {
+			if n = recognize(i); n > 0 {
+				break
+			}
+		}
+		if n == 0 {
+			i++
+			continue
+		}
+		for j := i; j < i+n; j++ {
+			p.used[j] = true
+		}
+		i += n
+	}
+}
+
+// raw returns the normalized word at i, or "" past the end.
+func (p *parser) raw(i int) string {
+	if i < 0 || i >= len(p.lower) {
+		return ""
+	}
+	return p.lower[i]
+}
+
+// word returns the normalized word at i without a trailing full stop.
+func (p *parser) word(i int) string {
+	return strings.TrimSuffix(p.raw(i), ".")
+}
+
+// name joins all words that are not part of a date or time.
+func (p *parser) name() string {
+	var parts []string
+	for i, w := range p.words {
+		if !p.used[i] {
+			parts = append(parts, w)
+		}
+	}
+	return strings.Trim(strings.Join(parts, " "), " ,;:-")
+}
+
+// day returns the given date or today.
+func (p *parser) day() time.Time {
+	if p.hasDate {
+		return p.date
+	}
+	return midnight(p.now)
+}
+
+// at returns the time c on the day of t.
+func (p *parser) at(t time.Time, c clock) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), c.hour, c.min, 0, 0, p.now.Location())
+}
+
+// midnight returns the start of the day of t.
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
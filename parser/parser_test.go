@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"testing"
+	"time"
+)
+
+const testLayout = "2006-01-02 15:04"
+
+// testNow is Wednesday, 12/03/2025 11:17 in Berlin.
+func testNow(t *testing.T) time.Time {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return time.Date(2025, 3, 12, 11, 17, 0, 0, loc)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input      string
+		name       string
+		start, end string
+		allDay     bool
+	}{
+		{"Standup tomorrow 10-12", "Standup", "2025-03-13 10:00", "2025-03-13 12:00", false},
+		{"Review next friday 9am", "Review", "2025-03-14 09:00", "2025-03-14 10:00", false},
+		{"Party 9-11pm", "Party", "2025-03-12 21:00", "2025-03-12 23:00", false},
+		{"Call in 2 hours for 30min", "Call", "2025-03-12 13:17", "2025-03-12 13:47", false},
+		{"Release 2025-04-01 10:00", "Release", "2025-04-01 10:00", "2025-04-01 11:00", false},
+
+		{"Zahnarzt am Montag um 14 Uhr", "Zahnarzt", "2025-03-17 14:00", "2025-03-17 15:00", false},
+		{"Treffen übermorgen von 10 bis 12 Uhr", "Treffen", "2025-03-14 10:00", "2025-03-14 12:00", false},
+		{"Anruf in 2 Stunden für 30min", "Anruf", "2025-03-12 13:17", "2025-03-12 13:47", false},
+		{"Meeting 20.03. 15:00", "Meeting", "2025-03-20 15:00", "2025-03-20 16:00", false},
+		{"Call morgen 14.30", "Call", "2025-03-13 14:30", "2025-03-13 15:30", false},
+
+		// dates without times are all-day events
+		{"Release 2025-04-01", "Release", "2025-04-01 00:00", "2025-04-02 00:00", true},
+		{"Urlaub 20/03-22/03", "Urlaub", "2025-03-20 00:00", "2025-03-23 00:00", true},
+		{"Konferenz 20/03/2025 for 3 days", "Konferenz", "2025-03-20 00:00", "2025-03-23 00:00", true},
+
+		// single start times, moved to tomorrow once they have passed today
+		{"Lunch 12:30", "Lunch", "2025-03-12 12:30", "2025-03-12 13:30", false},
+		{"Frühstück 10:00", "Frühstück", "2025-03-13 10:00", "2025-03-13 11:00", false},
+		{"Termin um 9", "Termin", "2025-03-13 09:00", "2025-03-13 10:00", false},
+	}
+
+	now := testNow(t)
+	for _, tt := range tests {
+		evt, err := Parse(tt.input, now)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.input, err)
+			continue
+		}
+		if evt.Name != tt.name {
+			t.Errorf("Parse(%q).Name = %q, want %q", tt.input, evt.Name, tt.name)
+		}
+		if start := evt.Start.Format(testLayout); start != tt.start {
+			t.Errorf("Parse(%q).Start = %v, want %v", tt.input, start, tt.start)
+		}
+		if end := evt.End.Format(testLayout); end != tt.end {
+			t.Errorf("Parse(%q).End = %v, want %v", tt.input, end, tt.end)
+		}
+		if evt.AllDay != tt.allDay {
+			t.Errorf("Parse(%q).AllDay = %v, want %v", tt.input, evt.AllDay, tt.allDay)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   error
+	}{
+		{"Zahnarzt", ErrNoDate},
+		{"morgen 10-12", ErrNoName},
+	}
+
+	now := testNow(t)
+	for _, tt := range tests {
+		if _, err := Parse(tt.input, now); err != tt.err {
+			t.Errorf("Parse(%q) error = %v, want %v", tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestParseRecurrence(t *testing.T) {
+	tests := []struct {
+		input string
+		start string
+		rrule string
+	}{
+		{"Standup every monday 10-11", "2025-03-17 10:00", "RRULE:FREQ=WEEKLY;BYDAY=MO"},
+		{"Jour fixe every 2 weeks 10-11", "2025-03-13 10:00", "RRULE:FREQ=WEEKLY;INTERVAL=2"},
+		{"Team every first friday 9am", "2025-04-04 09:00", "RRULE:FREQ=MONTHLY;BYDAY=1FR"},
+		{"Team every last friday 16-17", "2025-03-28 16:00", "RRULE:FREQ=MONTHLY;BYDAY=-1FR"},
+		{"Lauf every day 9am until 31/03/2025", "2025-03-13 09:00", "RRULE:FREQ=DAILY;UNTIL=20250331T215959Z"},
+
+		{"Sport jeden Dienstag 18-19 Uhr", "2025-03-18 18:00", "RRULE:FREQ=WEEKLY;BYDAY=TU"},
+		{"Planung jeden Montag und Donnerstag 14:00", "2025-03-13 14:00", "RRULE:FREQ=WEEKLY;BYDAY=MO,TH"},
+		{"Kurs jeden Montag 10-11 10 mal", "2025-03-17 10:00", "RRULE:FREQ=WEEKLY;BYDAY=MO;COUNT=10"},
+		{"Lauf täglich 7 Uhr bis 31.03.2025", "2025-03-13 07:00", "RRULE:FREQ=DAILY;UNTIL=20250331T215959Z"},
+		{"Geburtstag jährlich 20/03", "2025-03-20 00:00", "RRULE:FREQ=YEARLY"},
+	}
+
+	now := testNow(t)
+	for _, tt := range tests {
+		evt, err := Parse(tt.input, now)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.input, err)
+			continue
+		}
+		if start := evt.Start.Format(testLayout); start != tt.start {
+			t.Errorf("Parse(%q).Start = %v, want %v", tt.input, start, tt.start)
+		}
+		if len(evt.Recurrence) != 1 || evt.Recurrence[0] != tt.rrule {
+			t.Errorf("Parse(%q).Recurrence = %q, want %q", tt.input, evt.Recurrence, tt.rrule)
+		}
+	}
+}
+
+func TestParseTimeOnly(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"15:00", true},
+		{"10:00", true},
+		{"10-11", true},
+		{"morgen 15:00", false},
+		{"heute 10:00", false},
+		{"in 2 hours", false},
+		{"20/03", false},
+		{"every monday 10-11", false},
+	}
+
+	now := testNow(t)
+	for _, tt := range tests {
+		evt, err := ParseTime(tt.input, now)
+		if err != nil {
+			t.Errorf("ParseTime(%q): %v", tt.input, err)
+			continue
+		}
+		if evt.TimeOnly != tt.want {
+			t.Errorf("ParseTime(%q).TimeOnly = %v, want %v", tt.input, evt.TimeOnly, tt.want)
+		}
+	}
+}
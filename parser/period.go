@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"strings"
+	"time"
+)
+
+// Period is a span of time asked about, e.g. "next week" or
+// "thursday afternoon". Periods of whole days start at midnight of
+// their first day and end at midnight after their last day.
+type Period struct {
+	Start  time.Time
+	End    time.Time
+	AllDay bool
+}
+
+// ParsePeriod parses a span of time to look at, e.g. "today", "week",
+// "next month", "nächstes Wochenende", "friday", "12/03-15/03",
+// "thursday afternoon" or "tomorrow 10-14". Empty input is today.
+// Unlike Parse, the input must not contain anything else.
+func ParsePeriod(input string, now time.Time) (*Period, error) {
+	p := newParser(input, now)
+	var words []string
+	part, hasPart := dayPart{}, false
+	for _, w := range p.lower {
+		if dp, ok := dayParts[w]; ok {
+			part, hasPart = dp, true
+		} else if !periodFillers[w] {
+			words = append(words, w)
+		}
+	}
+
+	period, ok := namedPeriod(words, now)
+	if !ok {
+		evt, err := ParseTime(strings.Join(words, " "), now)
+		switch {
+		case len(words) == 0:
+			period = &Period{Start: midnight(now), End: midnight(now).AddDate(0, 0, 1), AllDay: true}
+		case err != nil:
+			return nil, err
+		case evt.Name != "" || len(evt.Recurrence) > 0:
+			return nil, ErrNoDate
+		default:
+			period = &Period{Start: evt.Start, End: evt.End, AllDay: evt.AllDay}
+		}
+	}
+
+	if hasPart {
+		//a part of the day only narrows a single day
+		if !period.AllDay || !period.End.Equal(period.Start.AddDate(0, 0, 1)) {
+			return nil, ErrNoDate
+		}
+		period = &Period{Start: p.at(period.Start, part.start), End: p.at(period.Start, part.end)}
+	}
+	return period, nil
+}
+
+// namedPeriod recognizes words like "week", "this month" or "next weekend".
+// The current week and month start today.
+func namedPeriod(words []string, now time.Time) (*Period, bool) {
+	offset := 0
+	switch len(words) {
+	case 1:
+	case 2:
+		n, ok := periodWords[words[0]]
+		if !ok {
+			return nil, false
+		}
+		offset = n
+	default:
+		return nil, false
+	}
+	unit, ok := periodUnits[strings.TrimSuffix(words[len(words)-1], ".")]
+	if !ok {
+		return nil, false
+	}
+
+	today := midnight(now)
+	var start, end time.Time
+	switch unit {
+	case "week":
+		//weeks start on monday
+		monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		start = monday.AddDate(0, 0, 7*offset)
+		end = start.AddDate(0, 0, 7)
+	case "month":
+		start = time.Date(today.Year(), today.Month()+time.Month(offset), 1, 0, 0, 0, 0, today.Location())
+		end = start.AddDate(0, 1, 0)
+	case "weekend":
+		//the weekend that is under way counts as this weekend
+		saturday := today.AddDate(0, 0, (int(time.Saturday)-int(today.Weekday())+7)%7)
+		if today.Weekday() == time.Sunday {
+			saturday = today.AddDate(0, 0, -1)
+		}
+		start = saturday.AddDate(0, 0, 7*offset)
+		end = start.AddDate(0, 0, 2)
+	}
+	if start.Before(today) {
+		start = today
+	}
+	return &Period{Start: start, End: end, AllDay: true}, true
+}
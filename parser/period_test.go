@@ -0,0 +1,56 @@
+package parser
+
+import "testing"
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		input      string
+		start, end string
+		allDay     bool
+	}{
+		{"", "2025-03-12 00:00", "2025-03-13 00:00", true},
+		{"heute", "2025-03-12 00:00", "2025-03-13 00:00", true},
+		{"tomorrow", "2025-03-13 00:00", "2025-03-14 00:00", true},
+		{"friday", "2025-03-14 00:00", "2025-03-15 00:00", true},
+		{"week", "2025-03-12 00:00", "2025-03-17 00:00", true},
+		{"nächste woche", "2025-03-17 00:00", "2025-03-24 00:00", true},
+		{"next month", "2025-04-01 00:00", "2025-05-01 00:00", true},
+		{"wochenende", "2025-03-15 00:00", "2025-03-17 00:00", true},
+		{"next weekend", "2025-03-22 00:00", "2025-03-24 00:00", true},
+		{"12/03/2025", "2025-03-12 00:00", "2025-03-13 00:00", true},
+		{"20/03-22/03", "2025-03-20 00:00", "2025-03-23 00:00", true},
+		// a range that is under way stays in this year
+		{"01/03-15/03", "2025-03-01 00:00", "2025-03-16 00:00", true},
+		{"donnerstag nachmittag", "2025-03-13 12:00", "2025-03-13 18:00", false},
+		{"thursday afternoon", "2025-03-13 12:00", "2025-03-13 18:00", false},
+		{"morgen abends", "2025-03-13 18:00", "2025-03-13 22:00", false},
+		{"morgen 10-14", "2025-03-13 10:00", "2025-03-13 14:00", false},
+	}
+
+	now := testNow(t)
+	for _, tt := range tests {
+		period, err := ParsePeriod(tt.input, now)
+		if err != nil {
+			t.Errorf("ParsePeriod(%q): %v", tt.input, err)
+			continue
+		}
+		if start := period.Start.Format(testLayout); start != tt.start {
+			t.Errorf("ParsePeriod(%q).Start = %v, want %v", tt.input, start, tt.start)
+		}
+		if end := period.End.Format(testLayout); end != tt.end {
+			t.Errorf("ParsePeriod(%q).End = %v, want %v", tt.input, end, tt.end)
+		}
+		if period.AllDay != tt.allDay {
+			t.Errorf("ParsePeriod(%q).AllDay = %v, want %v", tt.input, period.AllDay, tt.allDay)
+		}
+	}
+}
+
+func TestParsePeriodErrors(t *testing.T) {
+	now := testNow(t)
+	for _, input := range []string{"Zahnarzt", "every monday", "next week afternoon"} {
+		if _, err := ParsePeriod(input, now); err == nil {
+			t.Errorf("ParsePeriod(%q) succeeded, want an error", input)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// weekdayCodes are the RRULE names of the weekdays.
+var weekdayCodes = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// recurrence is how an event repeats.
+type recurrence struct {
+	freq     string // RRULE frequency, empty if the event does not repeat
+	interval int
+	byDay    []string
+	first    time.Time // first day matching byDay
+	until    time.Time // last day
+	count    int
+}
+
+// recurrence recognizes a recurrence at i like "every monday and thursday",
+// "every 2 weeks", "jeden ersten Freitag im Monat", "monthly on the last friday",
+// "weekly" or "montags".
+func (p *parser) recurrence(i int) int {
+	w := p.word(i)
+	if wd, ok := weekdayAdverbs[w]; ok {
+		p.repeat("WEEKLY", 1)
+		return p.weekdays(i, wd)
+	}
+	if w == "werktags" {
+		p.repeatOnWorkdays()
+		return 1
+	}
+	if adverb, ok := frequencyAdverbs[w]; ok {
+		p.repeat(adverb.freq, adverb.interval)
+		return 1 + p.recurrenceDays(i+1)
+	}
+	if !recurrenceWords[w] {
+		return 0
+	}
+
+	next := p.word(i + 1)
+	if freq, ok := frequencies[next]; ok {
+		p.repeat(freq, 1)
+		return 2 + p.recurrenceDays(i+2)
+	}
+	// "every 2 weeks", "every other week", "jede zweite Woche"
+	interval, err := strconv.Atoi(next)
+	if next == "other" {
+		interval, err = 2, nil
+	} else if n := ordinals[next]; n > 1 {
+		interval, err = n, nil
+	}
+	if freq, ok := frequencies[p.word(i+2)]; ok && err == nil && interval > 0 {
+		p.repeat(freq, interval)
+		return 3 + p.recurrenceDays(i+3)
+	}
+
+	if workdays[next] {
+		p.repeatOnWorkdays()
+		return 2
+	}
+	if wd, ok := weekdays[next]; ok {
+		p.repeat("WEEKLY", 1)
+		return 1 + p.weekdays(i+1, wd)
+	}
+	if n := p.nthWeekday(i + 1); n > 0 {
+		p.repeat("MONTHLY", 1)
+		return 1 + n
+	}
+	return 0
+}
+
+// recurrenceDays recognizes the days a recurrence falls on after its
+// frequency, e.g. "on monday" or "on the first friday".
+func (p *parser) recurrenceDays(i int) int {
+	j := i
+	if datePrefixes[p.word(j)] {
+		j++
+	}
+	if p.word(j) == "the" {
+		j++
+	}
+	if wd, ok := weekdays[p.word(j)]; ok {
+		return j - i + p.weekdays(j, wd)
+	}
+	if n := p.nthWeekday(j); n > 0 {
+		p.rec.freq = "MONTHLY"
+		return j - i + n
+	}
+	return 0
+}
+
+// weekdays recognizes a list of weekdays starting with wd at i, e.g.
+// "monday and thursday", and repeats the event on each of them.
+func (p *parser) weekdays(i int, wd time.Weekday) int {
+	days := []time.Weekday{wd}
+	n := 1
+	for {
+		j := i + n
+		if p.word(j) == "and" || p.word(j) == "und" {
+			j++
+		}
+		next, ok := weekdays[p.word(j)]
+		if !ok {
+			next, ok = weekdayAdverbs[p.word(j)]
+		}
+		if !ok {
+			break
+		}
+		days = append(days, next)
+		n = j + 1 - i
+	}
+
+	p.rec.byDay = nil
+	for _, d := range days {
+		p.rec.byDay = append(p.rec.byDay, weekdayCodes[d])
+		if day := upcomingWeekday(p.now, d); p.rec.first.IsZero() || day.Before(p.rec.first) {
+			p.rec.first = day
+		}
+	}
+	return n
+}
+
+// nthWeekday recognizes a weekday of the month at i like "first friday"
+// or "letzten Montag im Monat" and repeats the event on it.
+func (p *parser) nthWeekday(i int) int {
+	nth, ok := ordinals[p.word(i)]
+	if !ok {
+		return 0
+	}
+	wd, ok := weekdays[p.word(i+1)]
+	if !ok {
+		return 0
+	}
+	p.rec.byDay = []string{strconv.Itoa(nth) + weekdayCodes[wd]}
+	p.rec.first = nextNthWeekday(p.now, nth, wd)
+
+	// "of the month", "of every month", "im Monat"
+	n := 2
+	for _, filler := range []string{"of", "im", "in", "every", "the", "month", "monat"} {
+		if p.word(i+n) == filler {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *parser) repeat(freq string, interval int) {
+	p.rec.freq = freq
+	p.rec.interval = interval
+}
+
+func (p *parser) repeatOnWorkdays() {
+	p.repeat("WEEKLY", 1)
+	p.rec.byDay = []string{"MO", "TU", "WE", "TH", "FR"}
+	p.rec.first = midnight(p.now)
+	for p.rec.first.Weekday() == time.Saturday || p.rec.first.Weekday() == time.Sunday {
+		p.rec.first = p.rec.first.AddDate(0, 0, 1)
+	}
+}
+
+// recurrenceEnd recognizes the end of a recurrence at i like "until 31/12",
+// "bis zum 31.12." or "10 times". It only applies after a recurrence, so
+// "bis" still separates ranges of days and times otherwise.
+func (p *parser) recurrenceEnd(i int) int {
+	if p.rec.freq == "" {
+		return 0
+	}
+	if untilWords[p.word(i)] {
+		n := 1
+		if p.word(i+n) == "zum" {
+			n++
+		}
+		if d, words := p.dateAt(i + n); words > 0 {
+			p.rec.until = d
+			return n + words
+		}
+		return 0
+	}
+	if count, ok := amount(p.word(i)); ok && countWords[p.word(i+1)] {
+		p.rec.count = count
+		return 2
+	}
+	return 0
+}
+
+// rule returns the recurrence as RRULE line.
+func (r *recurrence) rule(allDay bool) string {
+	parts := []string{"FREQ=" + r.freq}
+	if r.interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.interval))
+	}
+	if len(r.byDay) > 0 {
+		parts = append(parts, "BYDAY="+strings.Join(r.byDay, ","))
+	}
+	switch {
+	case !r.until.IsZero() && allDay:
+		parts = append(parts, "UNTIL="+r.until.Format("20060102"))
+	case !r.until.IsZero():
+		// timed events need a UTC time, the series ends with the last day
+		last := r.until.AddDate(0, 0, 1).Add(-time.Second)
+		parts = append(parts, "UNTIL="+last.UTC().Format("20060102T150405Z"))
+	case r.count > 0:
+		parts = append(parts, "COUNT="+strconv.Itoa(r.count))
+	}
+	return "RRULE:" + strings.Join(parts, ";")
+}
+
+// upcomingWeekday returns today or the next day that falls on wd.
+func upcomingWeekday(now time.Time, wd time.Weekday) time.Time {
+	days := (int(wd) - int(now.Weekday()) + 7) % 7
+	return midnight(now).AddDate(0, 0, days)
+}
+
+// nextNthWeekday returns the next nth weekday wd of a month from today on.
+// A negative nth counts from the end of the month.
+func nextNthWeekday(now time.Time, nth int, wd time.Weekday) time.Time {
+	today := midnight(now)
+	for months := 0; months < 12; months++ {
+		d := nthWeekdayOfMonth(today.Year(), today.Month()+time.Month(months), nth, wd, now.Location())
+		if !d.IsZero() && !d.Before(today) {
+			return d
+		}
+	}
+	return today
+}
+
+// nthWeekdayOfMonth returns the nth weekday wd of a month,
+// or the zero time if the month has no such day.
+func nthWeekdayOfMonth(year int, month time.Month, nth int, wd time.Weekday, loc *time.Location) time.Time {
+	if nth < 0 {
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, loc)
+		return last.AddDate(0, 0, -((int(last.Weekday()) - int(wd) + 7) % 7))
+	}
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	d := first.AddDate(0, 0, (int(wd)-int(first.Weekday())+7)%7+(nth-1)*7)
+	if d.Month() != first.Month() {
+		return time.Time{}
+	}
+	return d
+}
@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockExpr matches times of day like 9, 09:30, 14.30, 9am or 9:30pm
+var clockExpr = regexp.MustCompile(`^(\d{1,2})(?:[:.](\d{2}))?(am|pm)?$`)
+
+// clock is a time of day.
+type clock struct {
+	hour, min int
+}
+
+// parseClock parses a time of day. explicit reports whether the word
+// is unmistakably a time, i.e. has minutes or an am/pm suffix. Dates
+// with dots need a trailing dot or a year, so 14.30 is a time.
+func parseClock(word string) (c clock, explicit bool, ok bool) {
+	m := clockExpr.FindStringSubmatch(word)
+	if m == nil {
+		return clock{}, false, false
+	}
+	c.hour, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		c.min, _ = strconv.Atoi(m[2])
+		explicit = true
+	}
+	if m[3] != "" {
+		if c, ok = c.withSuffix(m[3]); !ok {
+			return clock{}, false, false
+		}
+		explicit = true
+	}
+	if c.hour > 23 || c.min > 59 {
+		return clock{}, false, false
+	}
+	return c, explicit, true
+}
+
+// withSuffix converts a 12-hour clock with an am or pm suffix to 24 hours.
+func (c clock) withSuffix(suffix string) (clock, bool) {
+	if c.hour < 1 || c.hour > 12 {
+		return c, false
+	}
+	switch {
+	case suffix == "am" && c.hour == 12:
+		c.hour = 0
+	case suffix == "pm" && c.hour != 12:
+		c.hour += 12
+	}
+	return c, true
+}
+
+// times recognizes a time or a time range at i, like "10:00-12:00",
+// "10-12", "9am", "um 14 Uhr", "from 10 to 11:30" or "von 10 bis 12 Uhr".
+// Bare numbers are only taken as time if a prefix like "at" or "um",
+// a suffix like "Uhr" or a range makes it clear.
+func (p *parser) times(i int) int {
+	n := 0
+	prefixed := clockPrefixes[p.word(i)]
+	if prefixed {
+		n = 1
+	}
+
+	if start, end, ok := clockRange(p.word(i + n)); ok {
+		n++
+		if p.word(i+n) == "uhr" {
+			n++
+		}
+		p.setTimes(start, &end)
+		return n
+	}
+
+	start, explicit, ok := p.clockAt(i+n, &n)
+	if !ok {
+		return 0
+	}
+	if rangeSeparators[p.word(i+n)] {
+		m := n + 1
+		if end, _, ok := p.clockAt(i+m, &m); ok {
+			start = inferSuffix(start, end, p.word(i+n-1), p.word(i+m-1))
+			p.setTimes(start, &end)
+			return m
+		}
+	}
+	if !explicit && !prefixed {
+		return 0
+	}
+	p.setTimes(start, nil)
+	return n
+}
+
+// clockAt parses a time of day at i together with a following "Uhr",
+// "am" or "pm" word and advances n by the words used.
+func (p *parser) clockAt(i int, n *int) (c clock, explicit bool, ok bool) {
+	c, explicit, ok = parseClock(p.word(i))
+	if !ok {
+		return clock{}, false, false
+	}
+	*n++
+	switch next := p.word(i + 1); next {
+	case "uhr":
+		*n++
+		explicit = true
+	case "am", "pm":
+		if !strings.ContainsAny(p.word(i), "ap") {
+			if withSuffix, ok := c.withSuffix(next); ok {
+				c = withSuffix
+				*n++
+				explicit = true
+			}
+		}
+	}
+	return c, explicit, true
+}
+
+// clockRange parses a range written as one word like 10-12 or 9-11am.
+func clockRange(word string) (start, end clock, ok bool) {
+	parts := strings.Split(strings.Replace(word, "–", "-", 1), "-")
+	if len(parts) != 2 {
+		return clock{}, clock{}, false
+	}
+	start, _, ok = parseClock(parts[0])
+	if !ok {
+		return clock{}, clock{}, false
+	}
+	end, _, ok = parseClock(parts[1])
+	if !ok {
+		return clock{}, clock{}, false
+	}
+	return inferSuffix(start, end, parts[0], parts[1]), end, true
+}
+
+// inferSuffix applies a pm suffix of the end of a range to its start,
+// so "9-11pm" starts at 21:00.
+func inferSuffix(start, end clock, startWord, endWord string) clock {
+	if strings.HasSuffix(endWord, "pm") && !strings.ContainsAny(startWord, "ap:") &&
+		start.hour < 12 && start.hour+12 <= end.hour {
+		start.hour += 12
+	}
+	return start
+}
+
+func (p *parser) setTimes(start clock, end *clock) {
+	p.start = start
+	p.hasStart = true
+	if end != nil {
+		p.end = *end
+		p.hasEnd = true
+	}
+}
+
+// forDuration recognizes the length of an event like "for 30min",
+// "for an hour", "für 2 Stunden" or "for 3 days" at i.
+func (p *parser) forDuration(i int) int {
+	if !durationPrefixes[p.word(i)] {
+		return 0
+	}
+	n, unit, words := p.amountWithUnit(i + 1)
+	if words == 0 {
+		return 0
+	}
+	if d, ok := clockUnits[unit]; ok {
+		p.duration = time.Duration(n) * d
+		return 1 + words
+	}
+	if days, ok := dayUnits[unit]; ok {
+		p.days = n * days
+		return 1 + words
+	}
+	return 0
+}
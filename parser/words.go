@@ -0,0 +1,185 @@
+package parser
+
+import (
+	"strconv"
+	"time"
+)
+
+// weekdays maps English and German weekday names to weekdays.
+var weekdays = map[string]time.Weekday{
+	"monday": time.Monday, "mon": time.Monday, "montag": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday, "dienstag": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday, "mittwoch": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thurs": time.Thursday, "donnerstag": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday, "freitag": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday, "samstag": time.Saturday, "sonnabend": time.Saturday,
+	"sunday": time.Sunday, "sun": time.Sunday, "sonntag": time.Sunday,
+}
+
+// relativeDays maps words for days relative to today to their offset.
+var relativeDays = map[string]int{
+	"today":      0,
+	"heute":      0,
+	"tomorrow":   1,
+	"morgen":     1,
+	"übermorgen": 2,
+}
+
+// datePrefixes may precede any date, e.g. "on 12/03" or "am Montag".
+var datePrefixes = map[string]bool{
+	"on": true, "am": true,
+}
+
+// weekdayPrefixes may precede a weekday, e.g. "next friday".
+var weekdayPrefixes = map[string]bool{
+	"next": true, "this": true, "coming": true,
+	"nächsten": true, "nächster": true, "nächste": true,
+	"kommenden": true, "kommender": true, "diesen": true, "dieser": true,
+}
+
+// clockPrefixes mark the following number as a time of day, e.g. "at 10" or "um 14".
+var clockPrefixes = map[string]bool{
+	"at": true, "from": true, "um": true, "von": true, "ab": true,
+}
+
+// rangeSeparators separate the start and end of a time range, e.g. "10 to 12".
+var rangeSeparators = map[string]bool{
+	"-": true, "–": true, "to": true, "till": true, "until": true, "bis": true,
+}
+
+// dateSeparators separate the first and last day of a range of days, e.g. "12/03-15/03".
+var dateSeparators = map[string]bool{
+	"-": true, "–": true, "to": true, "bis": true,
+}
+
+// durationPrefixes introduce the length of an event, e.g. "for 30min".
+var durationPrefixes = map[string]bool{
+	"for": true, "für": true,
+}
+
+// clockUnits are units of durations below a day.
+var clockUnits = map[string]time.Duration{
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute,
+	"minute": time.Minute, "minutes": time.Minute, "minuten": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"std": time.Hour, "stunde": time.Hour, "stunden": time.Hour,
+}
+
+// dayUnits are units of durations in whole days.
+var dayUnits = map[string]int{
+	"day": 1, "days": 1, "tag": 1, "tage": 1, "tagen": 1,
+	"week": 7, "weeks": 7, "woche": 7, "wochen": 7,
+}
+
+// numberWords are amounts written as words, e.g. "in an hour".
+var numberWords = map[string]int{
+	"a": 1, "an": 1, "one": 1, "ein": 1, "eine": 1, "einer": 1, "einem": 1,
+	"two": 2, "zwei": 2, "three": 3, "drei": 3,
+}
+
+// amount parses a positive amount written as digits or as a word.
+func amount(word string) (int, bool) {
+	if n, ok := numberWords[word]; ok {
+		return n, true
+	}
+	n, err := strconv.Atoi(word)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// recurrenceWords introduce a recurrence, e.g. "every monday" or "alle 2 Wochen".
+var recurrenceWords = map[string]bool{
+	"every": true, "each": true, "jeden": true, "jede": true, "jedes": true, "alle": true,
+}
+
+// frequencies maps units of recurrences to RRULE frequencies.
+var frequencies = map[string]string{
+	"day": "DAILY", "days": "DAILY", "tag": "DAILY", "tage": "DAILY",
+	"week": "WEEKLY", "weeks": "WEEKLY", "woche": "WEEKLY", "wochen": "WEEKLY",
+	"month": "MONTHLY", "months": "MONTHLY", "monat": "MONTHLY", "monate": "MONTHLY",
+	"year": "YEARLY", "years": "YEARLY", "jahr": "YEARLY", "jahre": "YEARLY",
+}
+
+// frequencyAdverbs are recurrences written as one word, e.g. "weekly".
+var frequencyAdverbs = map[string]struct {
+	freq     string
+	interval int
+}{
+	"daily": {"DAILY", 1}, "täglich": {"DAILY", 1},
+	"weekly": {"WEEKLY", 1}, "wöchentlich": {"WEEKLY", 1},
+	"biweekly": {"WEEKLY", 2}, "fortnightly": {"WEEKLY", 2}, "zweiwöchentlich": {"WEEKLY", 2},
+	"monthly": {"MONTHLY", 1}, "monatlich": {"MONTHLY", 1},
+	"yearly": {"YEARLY", 1}, "annually": {"YEARLY", 1}, "jährlich": {"YEARLY", 1},
+}
+
+// weekdayAdverbs are German words for "every monday" and the like.
+var weekdayAdverbs = map[string]time.Weekday{
+	"montags": time.Monday, "dienstags": time.Tuesday, "mittwochs": time.Wednesday,
+	"donnerstags": time.Thursday, "freitags": time.Friday, "samstags": time.Saturday,
+	"sonntags": time.Sunday,
+}
+
+// workdays are words for every day from monday to friday, e.g. "every weekday".
+var workdays = map[string]bool{
+	"weekday": true, "weekdays": true, "werktag": true, "arbeitstag": true,
+}
+
+// ordinals are positions of a weekday in its month, e.g. "first friday".
+// -1 is the last one.
+var ordinals = map[string]int{
+	"first": 1, "1st": 1, "1": 1, "erste": 1, "ersten": 1, "erster": 1,
+	"second": 2, "2nd": 2, "2": 2, "zweite": 2, "zweiten": 2, "zweiter": 2,
+	"third": 3, "3rd": 3, "3": 3, "dritte": 3, "dritten": 3, "dritter": 3,
+	"fourth": 4, "4th": 4, "4": 4, "vierte": 4, "vierten": 4, "vierter": 4,
+	"last": -1, "letzte": -1, "letzten": -1, "letzter": -1,
+}
+
+// untilWords introduce the last day of a recurrence, e.g. "until 31/12".
+var untilWords = map[string]bool{
+	"until": true, "till": true, "bis": true,
+}
+
+// countWords follow the number of occurrences of a recurrence, e.g. "10 times".
+var countWords = map[string]bool{
+	"times": true, "mal": true,
+}
+
+// periodWords name spans of days, e.g. "next week". Their value is the
+// number of periods from the current one, the unit is in periodUnits.
+var periodWords = map[string]int{
+	"this": 0, "diese": 0, "dieser": 0, "diesen": 0, "dieses": 0,
+	"next": 1, "nächste": 1, "nächster": 1, "nächsten": 1, "nächstes": 1,
+	"coming": 1, "kommende": 1, "kommender": 1, "kommenden": 1, "kommendes": 1,
+}
+
+// periodUnits are the spans periodWords refer to.
+var periodUnits = map[string]string{
+	"week": "week", "woche": "week",
+	"month": "month", "monat": "month",
+	"weekend": "weekend", "wochenende": "weekend",
+}
+
+// periodFillers may surround a period without changing it, e.g. "in the next week".
+var periodFillers = map[string]bool{
+	"in": true, "the": true, "im": true, "der": true, "den": true, "am": true, "on": true,
+}
+
+// dayPart is a part of a day from start to end.
+type dayPart struct {
+	start, end clock
+}
+
+var (
+	morning   = dayPart{clock{8, 0}, clock{12, 0}}
+	afternoon = dayPart{clock{12, 0}, clock{18, 0}}
+	evening   = dayPart{clock{18, 0}, clock{22, 0}}
+)
+
+// dayParts are parts of a day, e.g. "afternoon".
+var dayParts = map[string]dayPart{
+	"morning": morning, "vormittag": morning, "vormittags": morning, "morgens": morning,
+	"afternoon": afternoon, "nachmittag": afternoon, "nachmittags": afternoon,
+	"evening": evening, "abend": evening, "abends": evening,
+}
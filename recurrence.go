@@ -0,0 +1,273 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yanzay/tbot"
+	"google.golang.org/api/calendar/v3"
+)
+
+// rrule is the part of an RRULE the bot creates and understands, e.g.
+// "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;UNTIL=20251231T225959Z".
+type rrule struct {
+	freq     string
+	interval int
+	byDay    []string // weekday codes like MO, for monthly rules with position like 1FR or -1FR
+	until    time.Time
+	count    int
+}
+
+var (
+	weekdayCodes = map[string]time.Weekday{
+		"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+		"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+	}
+	weekdayNames = [...]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"}
+	ordinalNames = map[int]string{1: "ersten", 2: "zweiten", 3: "dritten", 4: "vierten", 5: "fünften", -1: "letzten"}
+)
+
+// parseRRule finds the RRULE among the recurrence lines of an event.
+func parseRRule(recurrence []string) (rrule, bool) {
+	r := rrule{interval: 1}
+	for _, line := range recurrence {
+		if !strings.HasPrefix(line, "RRULE:") {
+			continue
+		}
+		for _, part := range strings.Split(strings.TrimPrefix(line, "RRULE:"), ";") {
+			kv := strings.SplitN(part, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "FREQ":
+				r.freq = kv[1]
+			case "INTERVAL":
+				if n, err := strconv.Atoi(kv[1]); err == nil && n > 0 {
+					r.interval = n
+				}
+			case "BYDAY":
+				r.byDay = strings.Split(kv[1], ",")
+			case "UNTIL":
+				if t, err := time.Parse("20060102T150405Z", kv[1]); err == nil {
+					r.until = t
+				} else if t, err := time.Parse("20060102", kv[1]); err == nil {
+					r.until = t.AddDate(0, 0, 1).Add(-time.Second)
+				}
+			case "COUNT":
+				r.count, _ = strconv.Atoi(kv[1])
+			}
+		}
+		return r, r.freq != ""
+	}
+	return r, false
+}
+
+// byDayCode splits a BYDAY entry like -1FR into its position and weekday.
+func byDayCode(code string) (int, time.Weekday, bool) {
+	if len(code) < 2 {
+		return 0, 0, false
+	}
+	wd, ok := weekdayCodes[code[len(code)-2:]]
+	if !ok {
+		return 0, 0, false
+	}
+	if len(code) == 2 {
+		return 0, wd, true
+	}
+	nth, err := strconv.Atoi(code[:len(code)-2])
+	return nth, wd, err == nil
+}
+
+// matches reports whether a series starting on the day of start
+// takes place on the day of d. Both are in the same location.
+func (r rrule) matches(start, d time.Time) bool {
+	if d.Before(start) && !sameDay(start, d) {
+		return false
+	}
+	switch r.freq {
+	case "DAILY":
+		return daysBetween(start, d)%r.interval == 0
+	case "WEEKLY":
+		weeks := daysBetween(weekStart(start), weekStart(d)) / 7
+		if weeks%r.interval != 0 {
+			return false
+		}
+		if len(r.byDay) == 0 {
+			return d.Weekday() == start.Weekday()
+		}
+		for _, code := range r.byDay {
+			if _, wd, ok := byDayCode(code); ok && wd == d.Weekday() {
+				return true
+			}
+		}
+		return false
+	case "MONTHLY":
+		months := (d.Year()-start.Year())*12 + int(d.Month()) - int(start.Month())
+		if months%r.interval != 0 {
+			return false
+		}
+		if len(r.byDay) == 0 {
+			return d.Day() == start.Day()
+		}
+		for _, code := range r.byDay {
+			nth, wd, ok := byDayCode(code)
+			if ok && wd == d.Weekday() && (nth == 0 || weekdayPosition(d, nth < 0) == nth) {
+				return true
+			}
+		}
+		return false
+	case "YEARLY":
+		return (d.Year()-start.Year())%r.interval == 0 && d.Month() == start.Month() && d.Day() == start.Day()
+	}
+	return false
+}
+
+// weekStart returns the monday of the week of t.
+func weekStart(t time.Time) time.Time {
+	return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+}
+
+// weekdayPosition returns which of the weekdays of its month d is,
+// counted from the end as negative number if fromEnd is set.
+func weekdayPosition(d time.Time, fromEnd bool) int {
+	if fromEnd {
+		last := time.Date(d.Year(), d.Month()+1, 0, 0, 0, 0, 0, d.Location())
+		return -((last.Day()-d.Day())/7 + 1)
+	}
+	return (d.Day()-1)/7 + 1
+}
+
+// describe tells in German how often a series repeats, e.g.
+// "alle 2 Wochen am Montag und Donnerstag bis 31/12/2025".
+func (r rrule) describe(loc *time.Location) string {
+	units := map[string][2]string{
+		"DAILY":   {"täglich", "Tage"},
+		"WEEKLY":  {"wöchentlich", "Wochen"},
+		"MONTHLY": {"monatlich", "Monate"},
+		"YEARLY":  {"jährlich", "Jahre"},
+	}
+	unit := units[r.freq]
+	text := unit[0]
+	if r.interval > 1 {
+		text = "alle " + strconv.Itoa(r.interval) + " " + unit[1]
+	}
+
+	var days []string
+	for _, code := range r.byDay {
+		nth, wd, ok := byDayCode(code)
+		if !ok {
+			continue
+		}
+		if nth != 0 {
+			days = append(days, ordinalNames[nth]+" "+weekdayNames[wd])
+		} else {
+			days = append(days, weekdayNames[wd])
+		}
+	}
+	switch {
+	case r.freq == "WEEKLY" && r.interval == 1 && strings.Join(r.byDay, ",") == "MO,TU,WE,TH,FR":
+		text = "werktags"
+	case len(days) > 1:
+		text += " am " + strings.Join(days[:len(days)-1], ", ") + " und " + days[len(days)-1]
+	case len(days) == 1:
+		text += " am " + days[0]
+	}
+
+	switch {
+	case !r.until.IsZero():
+		text += " bis " + r.until.In(loc).Format(dateFormat)
+	case r.count > 0:
+		text += ", " + strconv.Itoa(r.count) + " Mal"
+	}
+	return text
+}
+
+// seriesID returns the ID of the series an event belongs to,
+// or "" if it does not repeat.
+func seriesID(evt *calendar.Event) string {
+	if evt.RecurringEventId != "" {
+		return evt.RecurringEventId
+	}
+	if len(evt.Recurrence) > 0 {
+		return evt.Id
+	}
+	return ""
+}
+
+// isSeriesWord reports whether word asks to change a whole series
+// instead of a single event of it.
+func isSeriesWord(word string) bool {
+	switch strings.ToLower(word) {
+	case "serie", "series", "alle", "all":
+		return true
+	}
+	return false
+}
+
+// seriesEvent returns the first event of the series the event behind ref
+// belongs to, and a ref to it.
+func seriesEvent(backend CalendarBackend, ref eventRef) (eventRef, *calendar.Event, error) {
+	if ref.SeriesID == "" {
+		return ref, nil, parseError("Der Termin %v gehört zu keiner Serie.", ref.Summary)
+	}
+	evt, err := backend.Get(ref.CalendarID, ref.SeriesID)
+	if err != nil {
+		return ref, nil, err
+	}
+	if evt.Status == "cancelled" {
+		return ref, nil, notFoundError("Die Serie %v wurde inzwischen gelöscht.", ref.Summary)
+	}
+	ref.EventID = evt.Id
+	ref.Etag = evt.Etag
+	return ref, evt, nil
+}
+
+// formatSeriesTime describes when the first event of a series takes place
+// and how often it repeats.
+func formatSeriesTime(series *calendar.Event, loc *time.Location) string {
+	rule, ok := parseRRule(series.Recurrence)
+	if !ok {
+		return formatEventTime(series, loc)
+	}
+	return "ab " + formatEventTime(series, loc) + ", " + rule.describe(loc)
+}
+
+// InstancesHandler lists the upcoming events of the series an event
+// belongs to, each with its own handle, so single events of the series
+// can be changed or deleted.
+func InstancesHandler(message *tbot.Message) error {
+	ref, err := resolveRef(message.ChatID, strings.TrimSpace(message.Vars["handle"]))
+	if err != nil {
+		return err
+	}
+	backend, calendarID, err := chatBackend(message.ChatID, message.From.ID)
+	if err != nil {
+		return err
+	}
+	ref, series, err := seriesEvent(backend, ref)
+	if err != nil {
+		return err
+	}
+	events, err := backend.Instances(ref.CalendarID, ref.EventID, time.Now(), 10)
+	if err != nil {
+		return err
+	}
+	loc, err := userLocation(message.From.ID, backend, calendarID)
+	if err != nil {
+		return err
+	}
+	handles, err := issueRefs(message.ChatID, ref.CalendarID, events)
+	if err != nil {
+		return err
+	}
+
+	if len(events) == 0 {
+		message.Replyf("Die Serie %v hat keine anstehenden Termine mehr.", series.Summary)
+		return nil
+	}
+	message.Replyf("Die nächsten %v Termine der Serie %v (%v):", len(events), series.Summary, formatSeriesTime(series, loc))
+	replyEvents(message, events, handles, loc)
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRRule(t *testing.T) {
+	tests := []struct {
+		recurrence []string
+		ok         bool
+		freq       string
+		interval   int
+		byDay      string
+		until      string
+		count      int
+	}{
+		{nil, false, "", 1, "", "", 0},
+		{[]string{"EXDATE;TZID=Europe/Berlin:20250317T100000"}, false, "", 1, "", "", 0},
+		{[]string{"EXDATE;TZID=Europe/Berlin:20250317T100000", "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;UNTIL=20251231T225959Z"},
+			true, "WEEKLY", 2, "MO,TH", "2025-12-31T22:59:59Z", 0},
+		{[]string{"RRULE:FREQ=MONTHLY;BYDAY=-1FR;COUNT=5"}, true, "MONTHLY", 1, "-1FR", "", 5},
+		{[]string{"RRULE:FREQ=YEARLY;UNTIL=20261231"}, true, "YEARLY", 1, "", "2026-12-31T23:59:59Z", 0},
+	}
+
+	for _, tt := range tests {
+		r, ok := parseRRule(tt.recurrence)
+		if ok != tt.ok {
+			t.Errorf("parseRRule(%q) ok = %v, want %v", tt.recurrence, ok, tt.ok)
+			continue
+		}
+		until := ""
+		if !r.until.IsZero() {
+			until = r.until.Format(time.RFC3339)
+		}
+		if r.freq != tt.freq || r.interval != tt.interval || strings.Join(r.byDay, ",") != tt.byDay || until != tt.until || r.count != tt.count {
+			t.Errorf("parseRRule(%q) = %+v", tt.recurrence, r)
+		}
+	}
+}
+
+func TestRRuleMatches(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := func(s string) time.Time {
+		d, err := time.ParseInLocation(dateFormat, s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		rule  string
+		start string
+		day   string
+		want  bool
+	}{
+		{"FREQ=DAILY;INTERVAL=3", "17/03/2025", "20/03/2025", true},
+		{"FREQ=DAILY;INTERVAL=3", "17/03/2025", "19/03/2025", false},
+		{"FREQ=DAILY", "17/03/2025", "16/03/2025", false},
+		{"FREQ=WEEKLY", "17/03/2025", "24/03/2025", true},
+		{"FREQ=WEEKLY", "17/03/2025", "25/03/2025", false},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH", "17/03/2025", "20/03/2025", true},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH", "17/03/2025", "24/03/2025", false},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH", "17/03/2025", "31/03/2025", true},
+		{"FREQ=MONTHLY", "15/01/2025", "15/03/2025", true},
+		{"FREQ=MONTHLY;BYDAY=1FR", "07/03/2025", "04/04/2025", true},
+		{"FREQ=MONTHLY;BYDAY=1FR", "07/03/2025", "11/04/2025", false},
+		{"FREQ=MONTHLY;BYDAY=-1FR", "28/03/2025", "25/04/2025", true},
+		{"FREQ=MONTHLY;BYDAY=-1FR", "28/03/2025", "18/04/2025", false},
+		{"FREQ=YEARLY", "20/03/2025", "20/03/2026", true},
+		{"FREQ=YEARLY", "20/03/2025", "21/03/2026", false},
+	}
+
+	for _, tt := range tests {
+		r, _ := parseRRule([]string{"RRULE:" + tt.rule})
+		if got := r.matches(day(tt.start), day(tt.day)); got != tt.want {
+			t.Errorf("%v from %v matches %v = %v, want %v", tt.rule, tt.start, tt.day, got, tt.want)
+		}
+	}
+}
+
+func TestRRuleDescribe(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{"FREQ=DAILY", "täglich"},
+		{"FREQ=DAILY;INTERVAL=3", "alle 3 Tage"},
+		{"FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR", "werktags"},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;UNTIL=20251231T225959Z", "alle 2 Wochen am Montag und Donnerstag bis 31/12/2025"},
+		{"FREQ=MONTHLY;BYDAY=1FR;COUNT=5", "monatlich am ersten Freitag, 5 Mal"},
+		{"FREQ=MONTHLY;BYDAY=-1FR", "monatlich am letzten Freitag"},
+		{"FREQ=YEARLY", "jährlich"},
+	}
+
+	for _, tt := range tests {
+		r, _ := parseRRule([]string{"RRULE:" + tt.rule})
+		if got := r.describe(loc); got != tt.want {
+			t.Errorf("describe(%v) = %q, want %q", tt.rule, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	refsBucket = "refs"
+	// refTTL is how long a handle stays resolvable after it was last listed.
+	refTTL = 30 * 24 * time.Hour
+)
+
+// eventRef is what a short handle shown in a chat stands for.
+type eventRef struct {
+	CalendarID string    `json:"calendar_id"`
+	EventID    string    `json:"event_id"`
+	Etag       string    `json:"etag"`
+	SeriesID   string    `json:"series_id,omitempty"` // the series the event belongs to
+	Summary    string    `json:"summary"`
+	Listed     time.Time `json:"listed"`
+}
+
+// refsMu serializes read-modify-write cycles on the handles of a chat.
+var refsMu sync.Mutex
+
+// chatRefs loads the handles issued in a chat.
+func chatRefs(chatID int64) (map[string]eventRef, error) {
+	refs := make(map[string]eventRef)
+	_, err := store.Get(refsBucket, strconv.FormatInt(chatID, 10), &refs)
+	return refs, err
+}
+
+// issueRefs returns a short handle for each of the events, in the same order,
+// and remembers them for the chat. The handle of an event is derived from its
+// ID, so it stays the same each time the event is listed.
+func issueRefs(chatID int64, calendarID string, events []*calendar.Event) ([]string, error) {
+	refsMu.Lock()
+	defer refsMu.Unlock()
+
+	refs, err := chatRefs(chatID)
+	if err != nil {
+		return nil, err
+	}
+	for handle, ref := range refs {
+		if time.Since(ref.Listed) > refTTL {
+			delete(refs, handle)
+		}
+	}
+
+	handles := make([]string, len(events))
+	for i, evt := range events {
+		handle := refHandle(refs, calendarID, evt.Id)
+		refs[handle] = eventRef{
+			CalendarID: calendarID,
+			EventID:    evt.Id,
+			Etag:       evt.Etag,
+			SeriesID:   seriesID(evt),
+			Summary:    evt.Summary,
+			Listed:     time.Now(),
+		}
+		handles[i] = handle
+	}
+	return handles, store.Put(refsBucket, strconv.FormatInt(chatID, 10), refs)
+}
+
+// refHandle derives the handle of an event from a hash of its ID.
+// It is four characters long and only grows if another event of the
+// chat already uses the shorter one.
+func refHandle(refs map[string]eventRef, calendarID, eventID string) string {
+	h := fnv.New64a()
+	h.Write([]byte(calendarID + "/" + eventID))
+	full := strconv.FormatUint(h.Sum64(), 36)
+	for n := 4; n < len(full); n++ {
+		ref, taken := refs[full[:n]]
+		if !taken || (ref.CalendarID == calendarID && ref.EventID == eventID) {
+			return full[:n]
+		}
+	}
+	return full
+}
+
+// resolveRef returns the event a handle stands for in a chat.
+func resolveRef(chatID int64, handle string) (eventRef, error) {
+	refsMu.Lock()
+	defer refsMu.Unlock()
+
+	refs, err := chatRefs(chatID)
+	if err != nil {
+		return eventRef{}, err
+	}
+	ref, ok := refs[strings.ToLower(strings.Trim(handle, "[]"))]
+	if !ok {
+		return eventRef{}, notFoundError("Den Termin %v kenne ich nicht, schau mit /show nach.", handle)
+	}
+	return ref, nil
+}
+
+// currentEvent fetches the event behind ref and makes sure it has not
+// changed since it was listed.
+func currentEvent(backend CalendarBackend, ref eventRef) (*calendar.Event, error) {
+	evt, err := backend.Get(ref.CalendarID, ref.EventID)
+	if err != nil {
+		return nil, err
+	}
+	if evt.Status == "cancelled" {
+		return nil, notFoundError("Der Termin %v wurde inzwischen gelöscht.", ref.Summary)
+	}
+	if evt.Etag != ref.Etag {
+		return nil, &botError{kind: kindConflict, msg: "Der Termin " + ref.Summary +
+			" wurde geändert, seit er angezeigt wurde. Bitte sieh ihn dir mit /show noch einmal an."}
+	}
+	return evt, nil
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clndr/parser"
+	"github.com/yanzay/tbot"
+	"github.com/yanzay/tbot/model"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	remindersBucket = "reminders"
+	snoozesBucket   = "snoozes"
+
+	// defaultReminder is how many minutes before an event users are reminded,
+	// unless they chose otherwise with /reminder or the event has own reminders.
+	defaultReminder = 15
+	// reminderInterval is how often the scheduler looks for due reminders.
+	reminderInterval = time.Minute
+	// reminderLookahead is how many upcoming events of each user are checked.
+	reminderLookahead = 50
+	// reminderRefresh is how often the upcoming events of a user are
+	// fetched again, unless the calendar changed before.
+	reminderRefresh = 10 * time.Minute
+)
+
+// upcoming are the upcoming events of a user and the zone they are
+// shown in, kept between the runs of the reminder scheduler.
+type upcoming struct {
+	calendarID string
+	timeZone   string // the user's zone setting the location was found for
+	loc        *time.Location
+	events     []*calendar.Event
+	fetched    time.Time
+}
+
+var (
+	upcomingMu sync.Mutex
+	upcomings  = make(map[int]*upcoming) // user ID -> upcoming events
+)
+
+// forgetUpcoming makes the next run of the reminder scheduler fetch the
+// upcoming events of a user again, e.g. after the calendar changed.
+func forgetUpcoming(userID int) {
+	upcomingMu.Lock()
+	delete(upcomings, userID)
+	upcomingMu.Unlock()
+}
+
+// upcomingEvents returns the upcoming events of a user and the user's zone,
+// fetching them only if they are older than reminderRefresh.
+func upcomingEvents(u *user, now time.Time) (*upcoming, error) {
+	upcomingMu.Lock()
+	cached, ok := upcomings[u.ID]
+	upcomingMu.Unlock()
+	if ok && cached.calendarID == u.calendar() && cached.timeZone == u.TimeZone && now.Sub(cached.fetched) < reminderRefresh {
+		return cached, nil
+	}
+
+	backend, err := backendFor(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := userLocation(u.ID, backend, "")
+	if err != nil {
+		return nil, err
+	}
+	events, err := backend.Upcoming(u.calendar(), now, reminderLookahead)
+	if err != nil {
+		return nil, err
+	}
+	cached = &upcoming{calendarID: u.calendar(), timeZone: u.TimeZone, loc: loc, events: events, fetched: now}
+	upcomingMu.Lock()
+	upcomings[u.ID] = cached
+	upcomingMu.Unlock()
+	return cached, nil
+}
+
+// sentReminder records a reminder that was sent, so it is not sent
+// again after a restart. It is forgotten once the event started.
+type sentReminder struct {
+	Start time.Time `json:"start"`
+}
+
+// snooze is a reminder to be sent again later.
+type snooze struct {
+	UserID     int       `json:"user_id"`
+	ChatID     int64     `json:"chat_id"`
+	CalendarID string    `json:"calendar_id"`
+	EventID    string    `json:"event_id"`
+	At         time.Time `json:"at"`
+}
+
+// snoozeButtons are the inline buttons of a reminder. Their callback
+// data is the snooze action, the minutes and the handle of the event.
+func snoozeButtons(handle string) []map[string]string {
+	return []map[string]string{
+		{"In 5 Minuten noch einmal": "snooze:5:" + handle},
+		{"In 15 Minuten noch einmal": "snooze:15:" + handle},
+	}
+}
+
+// startReminders runs the reminder scheduler in the background.
+func startReminders() {
+	go func() {
+		for now := range time.Tick(reminderInterval) {
+			checkReminders(now)
+		}
+	}()
+}
+
+// checkReminders sends all reminders and snoozed reminders due at now.
+func checkReminders(now time.Time) {
+	users, err := allUsers()
+	if err != nil {
+		log.Printf("Error loading users for reminders: %v", err)
+		return
+	}
+	for _, u := range users {
+		if u.ChatID == 0 || (memory == nil && u.Token == nil) {
+			continue
+		}
+		if err := remindUser(u, now); err != nil {
+			log.Printf("Error sending reminders to user %v: %v", u.ID, err)
+		}
+	}
+	if err := sendSnoozed(now); err != nil {
+		log.Printf("Error sending snoozed reminders: %v", err)
+	}
+	if err := pruneReminders(now); err != nil {
+		log.Printf("Error pruning reminders: %v", err)
+	}
+}
+
+// remindUser sends the reminders of a user's upcoming events that are due.
+// If several reminders of an event are due at once, e.g. after the bot was
+// down, only one message is sent.
+func remindUser(u *user, now time.Time) error {
+	next, err := upcomingEvents(u, now)
+	if err != nil {
+		return err
+	}
+	loc := next.loc
+
+	for _, evt := range next.events {
+		start := eventStart(evt, loc)
+		if !start.After(now) {
+			continue
+		}
+		var due []string
+		for _, minutes := range reminderMinutes(evt, u) {
+			if start.Add(-time.Duration(minutes) * time.Minute).After(now) {
+				continue
+			}
+			key := fmt.Sprintf("%v/%v/%v/%v", u.ID, evt.Id, start.Unix(), minutes)
+			sent, err := store.Get(remindersBucket, key, &sentReminder{})
+			if err != nil {
+				return err
+			}
+			if !sent {
+				due = append(due, key)
+			}
+		}
+		if len(due) == 0 {
+			continue
+		}
+
+		if err := sendReminder(u.ChatID, u.calendar(), evt, loc, now); err != nil {
+			return err
+		}
+		for _, key := range due {
+			if err := store.Put(remindersBucket, key, sentReminder{Start: start}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reminderMinutes returns how many minutes before evt its reminders are due:
+// the event's own reminders, or else the user's default. All-day events,
+// e.g. birthdays or due todos, only have their own reminders.
+func reminderMinutes(evt *calendar.Event, u *user) []int {
+	if evt.Reminders != nil && !evt.Reminders.UseDefault {
+		var minutes []int
+		for _, r := range evt.Reminders.Overrides {
+			minutes = append(minutes, int(r.Minutes))
+		}
+		return minutes
+	}
+	switch {
+	case evt.Start.DateTime == "":
+		return nil
+	case u.Reminder == nil:
+		return []int{defaultReminder}
+	case *u.Reminder < 0:
+		return nil
+	}
+	return []int{*u.Reminder}
+}
+
+// sendReminder pushes a reminder of evt with snooze buttons to a chat.
+func sendReminder(chatID int64, calendarID string, evt *calendar.Event, loc *time.Location, now time.Time) error {
+	handles, err := issueRefs(chatID, calendarID, []*calendar.Event{evt})
+	if err != nil {
+		return err
+	}
+	text := fmt.Sprintf("Erinnerung: %v beginnt %v (%v)", evt.Summary, formatUntil(eventStart(evt, loc).Sub(now)), formatEventTime(evt, loc))
+	if evt.Location != "" {
+		text += "\nWo: " + evt.Location
+	}
+	return bot.SendMessage(&model.Message{
+		Type:                  model.MessageInlineKeyboard,
+		ChatID:                chatID,
+		Data:                  text,
+		InlineButtons:         snoozeButtons(handles[0]),
+		WithDataInlineButtons: true,
+	})
+}
+
+// sendSnoozed sends the snoozed reminders that are due.
+func sendSnoozed(now time.Time) error {
+	keys, err := store.Keys(snoozesBucket)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		s := snooze{}
+		if _, err := store.Get(snoozesBucket, key, &s); err != nil {
+			return err
+		}
+		if s.At.After(now) {
+			continue
+		}
+		if err := store.Delete(snoozesBucket, key); err != nil {
+			return err
+		}
+		if err := sendSnooze(s, now); err != nil {
+			log.Printf("Error sending snoozed reminder to user %v: %v", s.UserID, err)
+		}
+	}
+	return nil
+}
+
+// sendSnooze sends a snoozed reminder again, unless the event is gone.
+func sendSnooze(s snooze, now time.Time) error {
+	backend, err := backendFor(s.UserID)
+	if err != nil {
+		return err
+	}
+	evt, err := backend.Get(s.CalendarID, s.EventID)
+	if err != nil && classify(err).kind != kindNotFound {
+		return err
+	}
+	if err != nil || evt.Status == "cancelled" {
+		return nil
+	}
+	loc, err := userLocation(s.UserID, backend, "")
+	if err != nil {
+		return err
+	}
+	return sendReminder(s.ChatID, s.CalendarID, evt, loc, now)
+}
+
+// pruneReminders forgets sent reminders of events that have started.
+func pruneReminders(now time.Time) error {
+	keys, err := store.Keys(remindersBucket)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		sent := sentReminder{}
+		if _, err := store.Get(remindersBucket, key, &sent); err != nil {
+			return err
+		}
+		if sent.Start.Before(now) {
+			if err := store.Delete(remindersBucket, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// snoozeReminder handles the snooze buttons of a reminder, args are the
+// minutes and the handle of the event.
+func snoozeReminder(message *tbot.Message, args string) error {
+	parts := strings.SplitN(args, ":", 2)
+	minutes, err := strconv.Atoi(parts[0])
+	if len(parts) != 2 || err != nil || minutes < 1 {
+		return parseError("Mit diesem Knopf kann ich nichts anfangen.")
+	}
+	ref, err := resolveRef(message.ChatID, parts[1])
+	if err != nil {
+		return err
+	}
+	backend, err := backendFor(senderID(message))
+	if err != nil {
+		return err
+	}
+	loc, err := userLocation(senderID(message), backend, "")
+	if err != nil {
+		return err
+	}
+
+	s := snooze{
+		UserID:     senderID(message),
+		ChatID:     message.ChatID,
+		CalendarID: ref.CalendarID,
+		EventID:    ref.EventID,
+		At:         time.Now().Add(time.Duration(minutes) * time.Minute).Truncate(time.Minute),
+	}
+	key := fmt.Sprintf("%v/%v/%v", s.UserID, s.EventID, s.At.Unix())
+	if err := store.Put(snoozesBucket, key, s); err != nil {
+		return err
+	}
+	message.Replyf("Ich erinnere dich um %v noch einmal an %v.", s.At.In(loc).Format(clockFormat), ref.Summary)
+	return nil
+}
+
+// eventStart returns when an event starts, all-day events start at
+// midnight in loc.
+func eventStart(evt *calendar.Event, loc *time.Location) time.Time {
+	start := eventTime(evt.Start)
+	if evt.Start.DateTime == "" {
+		return time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+	}
+	return start
+}
+
+// formatUntil describes in German how long it is until an event starts,
+// e.g. "in 15 Minuten".
+func formatUntil(d time.Duration) string {
+	minutes := int((d + time.Minute/2) / time.Minute)
+	switch {
+	case minutes < 1:
+		return "jetzt"
+	case minutes == 1:
+		return "in 1 Minute"
+	case minutes < 60:
+		return fmt.Sprintf("in %v Minuten", minutes)
+	case minutes < 120:
+		return "in 1 Stunde"
+	case minutes < 48*60:
+		return fmt.Sprintf("in %v Stunden", minutes/60)
+	}
+	return fmt.Sprintf("in %v Tagen", minutes/(24*60))
+}
+
+// ReminderHandler shows how many minutes before events the user is
+// reminded for /reminder, and changes it for /reminder 30 or /reminder aus.
+// Reminders are sent to the private chat the command is sent from.
+func ReminderHandler(message *tbot.Message) error {
+	arg := strings.ToLower(strings.TrimSpace(message.Vars["minutes"]))
+	if arg == "" {
+		u, err := loadUser(message.From.ID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case u.Reminder == nil:
+			message.Replyf("Ich erinnere dich %v Minuten vor deinen Terminen, wenn im Termin keine eigenen Erinnerungen eingestellt sind. "+
+				"Ändern kannst du das z.B. mit /reminder 30 oder /reminder aus.", defaultReminder)
+		case *u.Reminder < 0:
+			message.Reply("Ich erinnere dich nur an Termine mit eigenen Erinnerungen. Einschalten kannst du das z.B. mit /reminder 15.")
+		default:
+			message.Replyf("Ich erinnere dich %v Minuten vor deinen Terminen, wenn im Termin keine eigenen Erinnerungen eingestellt sind.", *u.Reminder)
+		}
+		return nil
+	}
+
+	var minutes *int
+	switch arg {
+	case "aus", "off", "nein":
+		off := -1
+		minutes = &off
+	case "an", "on", "standard", "default":
+	default:
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			d, err := parser.ParseDuration(arg)
+			if err != nil || d%time.Minute != 0 {
+				return parseError("Bitte gib an, wie viele Minuten vorher ich dich erinnern soll, z.B. /reminder 30 oder /reminder 1h.")
+			}
+			n = int(d / time.Minute)
+		}
+		if n < 0 {
+			return parseError("Bitte gib an, wie viele Minuten vorher ich dich erinnern soll, z.B. /reminder 30 oder /reminder 1h.")
+		}
+		minutes = &n
+	}
+
+	err := updateUser(message.From.ID, func(u *user) {
+		u.Reminder = minutes
+		//group chats have negative IDs, reminders only go to the private chat
+		if message.ChatID > 0 {
+			u.ChatID = message.ChatID
+		}
+	})
+	if err != nil {
+		return err
+	}
+	switch {
+	case minutes == nil:
+		message.Replyf("Ich erinnere dich wieder %v Minuten vor deinen Terminen.", defaultReminder)
+	case *minutes < 0:
+		message.Reply("Ich erinnere dich nur noch an Termine mit eigenen Erinnerungen.")
+	default:
+		message.Replyf("Ich erinnere dich ab jetzt %v Minuten vor deinen Terminen.", *minutes)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestReminderMinutes(t *testing.T) {
+	timed := &calendar.EventDateTime{DateTime: "2025-03-14T10:00:00+01:00"}
+	allDay := &calendar.EventDateTime{Date: "2025-03-14"}
+	own := &calendar.EventReminders{Overrides: []*calendar.EventReminder{{Method: "popup", Minutes: 60}, {Method: "popup", Minutes: 10}}}
+	thirty, off := 30, -1
+
+	tests := []struct {
+		name     string
+		evt      *calendar.Event
+		reminder *int
+		want     string
+	}{
+		{"timed", &calendar.Event{Start: timed}, nil, "[15]"},
+		{"timed, user's minutes", &calendar.Event{Start: timed}, &thirty, "[30]"},
+		{"timed, reminders off", &calendar.Event{Start: timed}, &off, "[]"},
+		{"timed, own reminders", &calendar.Event{Start: timed, Reminders: own}, &off, "[60 10]"},
+		{"all-day", &calendar.Event{Start: allDay}, nil, "[]"},
+		{"all-day, default reminders", &calendar.Event{Start: allDay, Reminders: &calendar.EventReminders{UseDefault: true}}, &thirty, "[]"},
+		{"all-day, own reminders", &calendar.Event{Start: allDay, Reminders: own}, nil, "[60 10]"},
+	}
+	for _, tt := range tests {
+		got := fmt.Sprint(reminderMinutes(tt.evt, &user{Reminder: tt.reminder}))
+		if got != tt.want {
+			t.Errorf("%v: reminders %v minutes before, want %v", tt.name, got, tt.want)
+		}
+	}
+}
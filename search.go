@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/clndr/parser"
+	"github.com/yanzay/tbot"
+)
+
+const (
+	// searchMax is the most events /search lists.
+	searchMax = 20
+
+	searchUsage = "Bitte gib an, wonach ich suchen soll, z.B. /search Zahnarzt oder /search Planung in nächste woche."
+)
+
+// splitSearch splits the input of /search into the text to find and the
+// period to look in, which follows the last "in" or "im" that is followed
+// by a period, e.g. "Zahnarzt in nächster woche". period is nil without one.
+func splitSearch(text string, now time.Time) (string, *parser.Period) {
+	words := strings.Fields(text)
+	for i := len(words) - 2; i > 0; i-- {
+		if lower := strings.ToLower(words[i]); lower != "in" && lower != "im" {
+			continue
+		}
+		if period, err := parser.ParsePeriod(strings.Join(words[i+1:], " "), now); err == nil {
+			return strings.Join(words[:i], " "), period
+		}
+	}
+	return strings.Join(words, " "), nil
+}
+
+// SearchHandler lists the events matching a text for /search {text}, by
+// default the upcoming ones, with "in <period>" those of a period, e.g.
+// "/search Zahnarzt in nächsten monat". The text is looked for in the
+// name, description and location of the events and among their guests.
+func SearchHandler(message *tbot.Message) error {
+	text, calName := takeCalendar(strings.TrimSpace(message.Vars["text"]))
+	backend, calendarID, err := chatBackend(message.ChatID, message.From.ID)
+	if err != nil {
+		return err
+	}
+	calendarID, calName, err = targetCalendar(message.ChatID, backend, calendarID, calName)
+	if err != nil {
+		return err
+	}
+	loc, err := userLocation(message.From.ID, backend, calendarID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().In(loc)
+	query, period := splitSearch(text, now)
+	if query == "" {
+		return parseError(searchUsage)
+	}
+
+	from, to := now, time.Time{}
+	if period != nil {
+		from, to = period.Start, period.End
+	}
+	events, err := backend.Search(calendarID, query, from, to, searchMax)
+	if err != nil {
+		return err
+	}
+	in := ""
+	if calName != "" {
+		in = " in " + calName
+	}
+	if len(events) == 0 {
+		message.Replyf("Keine Termine mit %q%v gefunden.", query, in)
+		return nil
+	}
+	handles, err := issueRefs(message.ChatID, calendarID, events)
+	if err != nil {
+		return err
+	}
+	message.Replyf("Termine mit %q%v:\n%v\n\nMit dem Kürzel kannst du einen Termin z.B. mit /edit, /delete oder /invite ändern.",
+		query, in, formatAgenda(events, handles, from, loc))
+	return nil
+}
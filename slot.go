@@ -0,0 +1,518 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clndr/parser"
+	"github.com/yanzay/tbot"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	slotsBucket = "slots"
+
+	// slotCount is how many slots /slot proposes.
+	slotCount = 3
+	// maxSlot is the longest meeting /slot looks for.
+	maxSlot = 12 * time.Hour
+	// slotTTL is how long proposed slots can be booked.
+	slotTTL = 7 * 24 * time.Hour
+	// defaultSlotTitle is the name of booked meetings without name.
+	defaultSlotTitle = "Besprechung"
+
+	slotUsage = "So suche ich einen gemeinsamen Termin: /slot 1h nächste woche mit @anna @ben, " +
+		"mit Namen z.B. /slot Planung 30min morgen mit @anna."
+)
+
+// slotProposal are the slots proposed by /slot, booked from the inline
+// buttons of the reply.
+type slotProposal struct {
+	ChatID     int64         `json:"chat_id"`
+	UserID     int           `json:"user_id"` // who asked, the meeting goes into their calendar
+	CalendarID string        `json:"calendar_id"`
+	TimeZone   string        `json:"time_zone"`
+	Title      string        `json:"title"`
+	Attendees  []int         `json:"attendees"` // Telegram users invited besides UserID
+	Names      []string      `json:"names"`     // @usernames of the attendees
+	Duration   time.Duration `json:"duration"`
+	Starts     []time.Time   `json:"starts"`
+	Created    time.Time     `json:"created"`
+}
+
+// slotRequest is what /slot is asked for.
+type slotRequest struct {
+	title    string
+	duration time.Duration
+	period   string
+	names    []string
+	calendar string
+}
+
+// parseSlotRequest parses "[name] <duration> [period] [mit @user ...] [in:<calendar>]".
+func parseSlotRequest(text string) (*slotRequest, error) {
+	req := &slotRequest{}
+	text, req.calendar = takeCalendar(text)
+	words := strings.Fields(text)
+	for i, w := range words {
+		if lower := strings.ToLower(w); lower == "with" || lower == "mit" {
+			for _, name := range strings.FieldsFunc(strings.Join(words[i+1:], " "), func(r rune) bool { return r == ',' || r == ' ' }) {
+				if name == "und" || name == "and" {
+					continue
+				}
+				if !strings.HasPrefix(name, "@") {
+					return nil, parseError("Bitte gib die Teilnehmer als @username an, z.B. /slot 1h morgen mit @anna @ben.")
+				}
+				req.names = append(req.names, name)
+			}
+			words = words[:i]
+			break
+		}
+	}
+	for i, w := range words {
+		if d, ok := parseSlotDuration(w); ok {
+			req.title = strings.Join(words[:i], " ")
+			req.duration = d
+			req.period = strings.Join(words[i+1:], " ")
+			return req, nil
+		}
+	}
+	return nil, parseError(slotUsage)
+}
+
+// parseSlotDuration parses the length of a meeting like "1h", "1,5h",
+// "1h30", "90min" or "2std".
+func parseSlotDuration(word string) (time.Duration, bool) {
+	word = strings.NewReplacer(",", ".", "std", "h", "min", "m").Replace(strings.ToLower(word))
+	if strings.Contains(word, "h") && !strings.HasSuffix(word, "h") && !strings.HasSuffix(word, "m") {
+		word += "m"
+	}
+	d, err := time.ParseDuration(word)
+	if err != nil || d < minFree || d > maxSlot {
+		return 0, false
+	}
+	return d, true
+}
+
+// formatDuration describes the length of a meeting, e.g. "1 Stunde" or "90 Minuten".
+func formatDuration(d time.Duration) string {
+	switch {
+	case d == time.Hour:
+		return "1 Stunde"
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%v Stunden", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%v Minuten", int(d/time.Minute))
+}
+
+// periodIn returns a period of whole days on the same dates in loc, so
+// working hours of users in other time zones apply on their own days.
+func periodIn(period *parser.Period, loc *time.Location) *parser.Period {
+	if !period.AllDay {
+		return period
+	}
+	date := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+	return &parser.Period{Start: date(period.Start), End: date(period.End), AllDay: true}
+}
+
+// intersect returns the stretches of time both a and b cover. Both must be
+// sorted and must not overlap themselves.
+func intersect(a, b []span) []span {
+	var both []span
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		start, end := a[i].start, a[i].end
+		if b[j].start.After(start) {
+			start = b[j].start
+		}
+		if b[j].end.Before(end) {
+			end = b[j].end
+		}
+		if end.After(start) {
+			both = append(both, span{start: start, end: end})
+		}
+		if a[i].end.Before(b[j].end) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return both
+}
+
+// pickSlots returns up to n starts of meetings of length d in the free
+// windows. The earliest slot of each day comes first, so the proposals
+// spread over several days, followed by later slots on full hours.
+func pickSlots(free []span, d time.Duration, n int, loc *time.Location) []time.Time {
+	var candidates []time.Time
+	for _, w := range free {
+		for t := w.start; !t.Add(d).After(w.end); t = nextFullHour(t, loc) {
+			candidates = append(candidates, t)
+		}
+	}
+
+	var picked []time.Time
+	days := make(map[string]bool)
+	for _, t := range candidates {
+		day := t.In(loc).Format("2006-01-02")
+		if len(picked) < n && !days[day] {
+			picked = append(picked, t)
+			days[day] = true
+		}
+	}
+	for _, t := range candidates {
+		if len(picked) >= n {
+			break
+		}
+		if !containsTime(picked, t) {
+			picked = append(picked, t)
+		}
+	}
+	sort.Slice(picked, func(i, j int) bool { return picked[i].Before(picked[j]) })
+	return picked
+}
+
+// nextFullHour returns the next full hour after t on the clock in loc,
+// which is not a full hour in UTC in zones like Asia/Kolkata.
+func nextFullHour(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	past := time.Duration(local.Minute())*time.Minute + time.Duration(local.Second())*time.Second + time.Duration(local.Nanosecond())
+	return t.Add(time.Hour - past)
+}
+
+func containsTime(times []time.Time, t time.Time) bool {
+	for _, other := range times {
+		if other.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// attendeeBackend returns the backend and calendar of a Telegram user
+// named by @username who is asked to join a meeting.
+func attendeeBackend(name string) (int, CalendarBackend, string, error) {
+	id, ok, err := lookupUsername(name)
+	if err != nil {
+		return 0, nil, "", err
+	}
+	if !ok {
+		return 0, nil, "", notFoundError("%v kenne ich noch nicht, %v muss mir zuerst schreiben und den Kalender mit /connect verbinden.", name, name)
+	}
+	backend, err := backendFor(id)
+	if err == errNotConnected {
+		return 0, nil, "", notFoundError("%v hat noch keinen Google Kalender mit /connect verbunden.", name)
+	}
+	if err != nil {
+		return 0, nil, "", err
+	}
+	u, err := loadUser(id)
+	if err != nil {
+		return 0, nil, "", err
+	}
+	return id, backend, u.calendar(), nil
+}
+
+// senderBusy returns the busy periods of the sender's own default calendar
+// in a group bound to another calendar, so slots are free for the sender
+// as well. Without a connected account of the sender there are none.
+func senderBusy(message *tbot.Message, calendarID string, period *parser.Period) ([]*calendar.TimePeriod, error) {
+	g, bound, err := loadGroup(message.ChatID)
+	if err != nil || !bound {
+		return nil, err
+	}
+	backend, err := backendFor(message.From.ID)
+	if err == errNotConnected {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	u, err := loadUser(message.From.ID)
+	if err != nil {
+		return nil, err
+	}
+	if g.OwnerID == message.From.ID && u.calendar() == calendarID {
+		//the calendar of the group is the sender's own
+		return nil, nil
+	}
+	return backend.Busy([]string{u.calendar()}, period.Start, period.End)
+}
+
+// SlotHandler proposes common free slots of the sender and other users for
+// /slot {args}, e.g. "/slot 1h nächste woche mit @anna @ben". The slots lie
+// within the working hours of everybody and are booked from inline buttons.
+func SlotHandler(message *tbot.Message) error {
+	req, err := parseSlotRequest(message.Vars["args"])
+	if err != nil {
+		return err
+	}
+	backend, calendarID, err := chatBackend(message.ChatID, message.From.ID)
+	if err != nil {
+		return err
+	}
+	calendarID, _, err = targetCalendar(message.ChatID, backend, calendarID, req.calendar)
+	if err != nil {
+		return err
+	}
+	loc, err := userLocation(message.From.ID, backend, calendarID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().In(loc)
+	period, err := parser.ParsePeriod(req.period, now)
+	if err != nil {
+		return parseError(slotUsage)
+	}
+	if period.End.Sub(period.Start) > maxFreeDays*24*time.Hour {
+		return parseError("Ich kann höchstens %v Tage auf einmal durchsehen.", maxFreeDays)
+	}
+	hours, err := userWorkHours(message.From.ID)
+	if err != nil {
+		return err
+	}
+
+	windows := workWindows(period, hours, now)
+	periods, err := backend.Busy([]string{calendarID}, period.Start, period.End)
+	if err != nil {
+		return err
+	}
+	own, err := senderBusy(message, calendarID, period)
+	if err != nil {
+		return err
+	}
+	periods = append(periods, own...)
+	p := &slotProposal{
+		ChatID:     message.ChatID,
+		UserID:     message.From.ID,
+		CalendarID: calendarID,
+		TimeZone:   loc.String(),
+		Title:      req.title,
+		Duration:   req.duration,
+		Created:    now,
+	}
+	for _, name := range req.names {
+		id, attendee, attendeeCalendar, err := attendeeBackend(name)
+		if err != nil {
+			return err
+		}
+		if id == message.From.ID || containsInt(p.Attendees, id) {
+			continue
+		}
+		attendeeLoc, err := userLocation(id, attendee, attendeeCalendar)
+		if err != nil {
+			return err
+		}
+		attendeeHours, err := userWorkHours(id)
+		if err != nil {
+			return err
+		}
+		windows = intersect(windows, workWindows(periodIn(period, attendeeLoc), attendeeHours, now))
+		busy, err := attendee.Busy([]string{attendeeCalendar}, period.Start, period.End)
+		if err != nil {
+			return err
+		}
+		periods = append(periods, busy...)
+		p.Attendees = append(p.Attendees, id)
+		p.Names = append(p.Names, name)
+	}
+
+	busy, err := busySpans(periods)
+	if err != nil {
+		return err
+	}
+	p.Starts = pickSlots(freeWindows(windows, busy, req.duration), req.duration, slotCount, loc)
+	if len(p.Starts) == 0 {
+		message.Replyf("In diesem Zeitraum finde ich keine gemeinsame freie Zeit von %v.", formatDuration(req.duration))
+		return nil
+	}
+
+	pruneSlots()
+	key, err := randomHex(8)
+	if err != nil {
+		return err
+	}
+	if err := store.Put(slotsBucket, key, p); err != nil {
+		return err
+	}
+	var buttons []map[string]string
+	for i, start := range p.Starts {
+		start = start.In(loc)
+		label := weekdayNames[start.Weekday()] + ", " + start.Format(dateTimeFormat) + "-" + start.Add(p.Duration).Format(clockFormat)
+		buttons = append(buttons, map[string]string{label: "slot:" + key + ":" + strconv.Itoa(i)})
+	}
+	text := "Freie Termine für " + formatDuration(p.Duration)
+	if len(p.Names) > 0 {
+		text += " mit " + strings.Join(p.Names, ", ")
+	}
+	message.ReplyInlineKeyboard(text+", zum Eintragen bitte auswählen:", buttons, tbot.WithDataInlineButtons)
+	return nil
+}
+
+func containsInt(values []int, v int) bool {
+	for _, other := range values {
+		if other == v {
+			return true
+		}
+	}
+	return false
+}
+
+// bookSlot books a slot proposed by /slot for a press on its button,
+// whose callback data is the key of the proposal and the number of the slot.
+// Slots that got busy since they were proposed are refused.
+func bookSlot(message *tbot.Message, args string) error {
+	parts := strings.SplitN(args, ":", 2)
+	if len(parts) != 2 {
+		return parseError("Mit diesem Knopf kann ich nichts anfangen.")
+	}
+	p, err := claimSlots(parts[0], message.ChatID)
+	if err != nil {
+		return err
+	}
+	booked := false
+	defer func() {
+		//a proposal that was not booked can be chosen from again
+		if !booked {
+			if err := store.Put(slotsBucket, parts[0], p); err != nil {
+				log.Printf("Error keeping proposed slots %v: %v", parts[0], err)
+			}
+		}
+	}()
+	i, err := strconv.Atoi(parts[1])
+	if err != nil || i < 0 || i >= len(p.Starts) {
+		return notFoundError("Diesen Vorschlag gibt es nicht mehr, bitte suche mit /slot neu.")
+	}
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return err
+	}
+	start := p.Starts[i].In(loc)
+	if start.Before(time.Now()) {
+		return parseError("Dieser Termin ist schon vorbei, bitte suche mit /slot neu.")
+	}
+
+	evt := &calendar.Event{
+		Summary: p.Title,
+		Start:   newDateTime(start, false),
+		End:     newDateTime(start.Add(p.Duration), false),
+	}
+	if evt.Summary == "" {
+		evt.Summary = defaultSlotTitle
+	}
+	for _, id := range p.Attendees {
+		email, err := userEmail(id)
+		if err != nil {
+			return err
+		}
+		evt.Attendees = append(evt.Attendees, &calendar.EventAttendee{Email: email})
+	}
+	attribute(message, evt)
+
+	backend, _, err := chatBackend(p.ChatID, p.UserID)
+	if err != nil {
+		return err
+	}
+	free, err := slotFree(p, backend, start)
+	if err != nil {
+		return err
+	}
+	if !free {
+		return &botError{kind: kindConflict, msg: "Zu dieser Zeit ist inzwischen jemand belegt, bitte wähle einen anderen Vorschlag oder suche mit /slot neu."}
+	}
+	created, err := backend.Insert(p.CalendarID, evt, sendUpdatesAll)
+	if err != nil {
+		return err
+	}
+	booked = true
+	handles, err := issueRefs(message.ChatID, p.CalendarID, []*calendar.Event{created})
+	if err != nil {
+		return err
+	}
+	with := ""
+	if len(p.Names) > 0 {
+		with = " mit " + strings.Join(p.Names, ", ")
+	}
+	message.Replyf("Termin %v (%v)%v eingetragen%v [%v]", created.Summary, formatEventTime(created, loc), with, byMember(message), handles[0])
+	return nil
+}
+
+// slotsMu makes sure a proposal is only claimed once.
+var slotsMu sync.Mutex
+
+// claimSlots takes the proposal behind a slot button out of the store, so
+// pressing a button again or another button of it cannot book a second
+// meeting while the first is booked. Unless it is booked, the caller puts
+// it back.
+func claimSlots(key string, chatID int64) (*slotProposal, error) {
+	slotsMu.Lock()
+	defer slotsMu.Unlock()
+	p := &slotProposal{}
+	ok, err := store.Get(slotsBucket, key, p)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || p.ChatID != chatID {
+		return nil, notFoundError("Diesen Vorschlag gibt es nicht mehr, bitte suche mit /slot neu.")
+	}
+	return p, store.Delete(slotsBucket, key)
+}
+
+// slotFree reports whether the calendar of a proposal and the calendars of
+// its attendees are still free for the meeting starting at start.
+func slotFree(p *slotProposal, backend CalendarBackend, start time.Time) (bool, error) {
+	end := start.Add(p.Duration)
+	periods, err := backend.Busy([]string{p.CalendarID}, start, end)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range p.Attendees {
+		attendee, err := backendFor(id)
+		if err != nil {
+			return false, err
+		}
+		u, err := loadUser(id)
+		if err != nil {
+			return false, err
+		}
+		busy, err := attendee.Busy([]string{u.calendar()}, start, end)
+		if err != nil {
+			return false, err
+		}
+		periods = append(periods, busy...)
+	}
+	busy, err := busySpans(periods)
+	if err != nil {
+		return false, err
+	}
+	for _, b := range busy {
+		if b.start.Before(end) && b.end.After(start) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// pruneSlots forgets proposals that can no longer be booked.
+func pruneSlots() {
+	keys, err := store.Keys(slotsBucket)
+	if err != nil {
+		log.Printf("Error pruning proposed slots: %v", err)
+		return
+	}
+	for _, key := range keys {
+		p := &slotProposal{}
+		if ok, err := store.Get(slotsBucket, key, p); err != nil || !ok || time.Since(p.Created) < slotTTL {
+			continue
+		}
+		if err := store.Delete(slotsBucket, key); err != nil {
+			log.Printf("Error pruning proposed slots: %v", err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yanzay/tbot"
+)
+
+func TestParseSlotRequest(t *testing.T) {
+	tests := []struct {
+		text     string
+		title    string
+		duration time.Duration
+		period   string
+		names    string
+		calendar string
+	}{
+		{"1h", "", time.Hour, "", "", ""},
+		{"1h nächste woche mit @anna @ben", "", time.Hour, "nächste woche", "@anna @ben", ""},
+		{"Planung 30min morgen with @anna, @ben und @carl in:Team", "Planung", 30 * time.Minute, "morgen", "@anna @ben @carl", "Team"},
+		{"Review 1,5h freitag", "Review", 90 * time.Minute, "freitag", "", ""},
+		{"1h30 donnerstag nachmittag", "", 90 * time.Minute, "donnerstag nachmittag", "", ""},
+		{"2std mit @anna", "", 2 * time.Hour, "", "@anna", ""},
+	}
+
+	for _, tt := range tests {
+		req, err := parseSlotRequest(tt.text)
+		if err != nil {
+			t.Errorf("parseSlotRequest(%q): %v", tt.text, err)
+			continue
+		}
+		if req.title != tt.title || req.duration != tt.duration || req.period != tt.period ||
+			strings.Join(req.names, " ") != tt.names || req.calendar != tt.calendar {
+			t.Errorf("parseSlotRequest(%q) = %+v", tt.text, req)
+		}
+	}
+
+	for _, text := range []string{"", "morgen mit @anna", "1h morgen mit anna", "10min morgen", "13h morgen"} {
+		if _, err := parseSlotRequest(text); err == nil {
+			t.Errorf("parseSlotRequest(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	loc := testLocation(t)
+	spans := func(s string) []span {
+		var spans []span
+		for _, part := range strings.Fields(s) {
+			times := strings.Split(part, "-")
+			start, _ := time.ParseInLocation(testLayout, "2025-03-13 "+times[0], loc)
+			end, _ := time.ParseInLocation(testLayout, "2025-03-13 "+times[1], loc)
+			spans = append(spans, span{start: start, end: end})
+		}
+		return spans
+	}
+
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"09:00-17:00", "10:00-14:00", "2025-03-13 10:00-14:00"},
+		{"09:00-12:00 13:00-17:00", "10:00-14:00", "2025-03-13 10:00-12:00, 2025-03-13 13:00-14:00"},
+		{"09:00-12:00 13:00-17:00", "08:00-09:30 11:00-13:30 16:00-18:00",
+			"2025-03-13 09:00-09:30, 2025-03-13 11:00-12:00, 2025-03-13 13:00-13:30, 2025-03-13 16:00-17:00"},
+		{"09:00-10:00", "10:00-11:00", ""},
+		{"09:00-10:00", "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := formatSpans(intersect(spans(tt.a), spans(tt.b))); got != tt.want {
+			t.Errorf("intersect(%v, %v) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPickSlots(t *testing.T) {
+	spans := func(s string, loc *time.Location) []span {
+		var spans []span
+		for _, part := range strings.Split(s, ", ") {
+			dash := strings.LastIndex(part, "-")
+			start, _ := time.ParseInLocation(testLayout, part[:dash], loc)
+			end, _ := time.ParseInLocation(testLayout, part[:11]+part[dash+1:], loc)
+			spans = append(spans, span{start: start, end: end})
+		}
+		return spans
+	}
+	format := func(times []time.Time, loc *time.Location) string {
+		var parts []string
+		for _, t := range times {
+			parts = append(parts, t.In(loc).Format(testLayout))
+		}
+		return strings.Join(parts, ", ")
+	}
+
+	tests := []struct {
+		zone string
+		free string
+		d    time.Duration
+		want string
+	}{
+		//the earliest slot of each day first
+		{"Europe/Berlin", "2025-03-13 09:15-12:00, 2025-03-14 14:00-15:00, 2025-03-17 09:00-17:00", time.Hour,
+			"2025-03-13 09:15, 2025-03-14 14:00, 2025-03-17 09:00"},
+		//then later slots on full hours
+		{"Europe/Berlin", "2025-03-13 09:15-12:00", time.Hour, "2025-03-13 09:15, 2025-03-13 10:00, 2025-03-13 11:00"},
+		{"Europe/Berlin", "2025-03-13 09:15-12:00, 2025-03-14 14:00-15:00", 90 * time.Minute, "2025-03-13 09:15, 2025-03-13 10:00"},
+		//full hours of the clock in zones half an hour off UTC
+		{"Asia/Kolkata", "2025-03-13 09:15-12:00", time.Hour, "2025-03-13 09:15, 2025-03-13 10:00, 2025-03-13 11:00"},
+		//windows too short for the meeting
+		{"Europe/Berlin", "2025-03-13 09:15-10:00, 2025-03-14 14:00-14:30", time.Hour, ""},
+	}
+
+	for _, tt := range tests {
+		loc, err := time.LoadLocation(tt.zone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := format(pickSlots(spans(tt.free, loc), tt.d, slotCount, loc), loc); got != tt.want {
+			t.Errorf("pickSlots(%v, %v) in %v = %q, want %q", tt.free, tt.d, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestBookSlot(t *testing.T) {
+	setupHandlers(t)
+	loc := testLocation(t)
+	day := time.Now().In(loc).AddDate(0, 0, 2)
+	at := func(hour int) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), hour, 0, 0, 0, loc)
+	}
+	p := &slotProposal{
+		ChatID: testChat, UserID: testUser, CalendarID: "primary", TimeZone: loc.String(),
+		Title: "Planung", Duration: time.Hour, Starts: []time.Time{at(10), at(14)}, Created: time.Now(),
+	}
+	if err := store.Put(slotsBucket, "k1", p); err != nil {
+		t.Fatal(err)
+	}
+	//the first slot got busy after it was proposed
+	sendOne(t, CreateTaskHandler, tbot.MessageVars{"eventstring": "Arzt " + at(10).Format("02.01.2006") + " 10:30"})
+
+	press := func(slot string) string {
+		t.Helper()
+		replies := send(func(message *tbot.Message) error { return bookSlot(message, "k1:"+slot) }, testChat, testUser, nil)
+		if len(replies) != 1 {
+			t.Fatalf("pressing slot %v replied %q", slot, replies)
+		}
+		return replies[0]
+	}
+	wantContains(t, press("0"), "inzwischen jemand belegt")
+	wantContains(t, press("1"), "Termin Planung (", "14:00-15:00) eingetragen")
+	//pressed again, or another slot of the booked proposal
+	wantContains(t, press("1"), "gibt es nicht mehr")
+	wantContains(t, press("0"), "gibt es nicht mehr")
+
+	events, err := memory.Between("primary", at(0), at(23), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("%v events on the day, want the busy one and the booked slot", len(events))
+	}
+}
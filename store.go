@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Store persists the bot's state as JSON encoded values grouped into buckets.
+// Other storage engines can be plugged in by implementing this interface.
+type Store interface {
+	// Get decodes the value stored under key into v and reports whether it exists.
+	Get(bucket, key string, v interface{}) (bool, error)
+	// Put stores v under key.
+	Put(bucket, key string, v interface{}) error
+	// Delete removes key, it is not an error if it does not exist.
+	Delete(bucket, key string) error
+	// Keys returns the sorted keys of a bucket.
+	Keys(bucket string) ([]string, error)
+}
+
+// fileStore is a Store keeping all buckets in a single JSON file.
+// The file is rewritten atomically on every change.
+// With an empty path it only keeps the data in memory.
+type fileStore struct {
+	mu      sync.Mutex
+	path    string
+	buckets map[string]map[string]json.RawMessage
+}
+
+// newFileStore opens the store at path, creating it on the first write.
+func newFileStore(path string) (*fileStore, error) {
+	fs := &fileStore{path: path, buckets: make(map[string]map[string]json.RawMessage)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fs.buckets); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// newMemoryStore creates a Store that is lost when the bot stops.
+func newMemoryStore() *fileStore {
+	return &fileStore{buckets: make(map[string]map[string]json.RawMessage)}
+}
+
+func (fs *fileStore) Get(bucket, key string, v interface{}) (bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	raw, ok := fs.buckets[bucket][key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+func (fs *fileStore) Put(bucket, key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.buckets[bucket] == nil {
+		fs.buckets[bucket] = make(map[string]json.RawMessage)
+	}
+	fs.buckets[bucket][key] = raw
+	return fs.save()
+}
+
+func (fs *fileStore) Delete(bucket, key string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.buckets[bucket][key]; !ok {
+		return nil
+	}
+	delete(fs.buckets[bucket], key)
+	return fs.save()
+}
+
+func (fs *fileStore) Keys(bucket string) ([]string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	keys := make([]string, 0, len(fs.buckets[bucket]))
+	for key := range fs.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// save writes all buckets to a temporary file and moves it over the store file,
+// so a crash never leaves a half written store behind.
+func (fs *fileStore) save() error {
+	if fs.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(fs.buckets)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(fs.path), filepath.Base(fs.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fs.path)
+}
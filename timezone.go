@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/yanzay/tbot"
+)
+
+// userLocation returns the time zone a user enters and reads times in:
+// the zone set with /timezone, or else the zone of calendarID, e.g. the
+// group calendar in a bound group. An empty calendarID stands for the
+// user's default calendar.
+func userLocation(userID int, backend CalendarBackend, calendarID string) (*time.Location, error) {
+	u, err := loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if calendarID == "" {
+		calendarID = u.calendar()
+	}
+	name := u.TimeZone
+	if name == "" {
+		if name, err = backend.TimeZone(calendarID); err != nil {
+			return nil, err
+		}
+	}
+	return time.LoadLocation(name)
+}
+
+// TimezoneHandler shows the user's time zone for /timezone,
+// sets it for /timezone {zone} and goes back to the calendar's zone
+// for /timezone auto.
+func TimezoneHandler(message *tbot.Message) error {
+	zone := strings.TrimSpace(message.Vars["zone"])
+	if zone == "" {
+		backend, err := backendFor(message.From.ID)
+		if err != nil {
+			return err
+		}
+		loc, err := userLocation(message.From.ID, backend, "")
+		if err != nil {
+			return err
+		}
+		message.Replyf("Deine Zeitzone ist %v (aktuell %v). Ändern kannst du sie z.B. mit /timezone Europe/London, "+
+			"mit /timezone auto gilt wieder die Zeitzone des Kalenders.", loc, time.Now().In(loc).Format(clockFormat))
+		return nil
+	}
+
+	if strings.EqualFold(zone, "auto") {
+		zone = ""
+	} else {
+		loc, err := time.LoadLocation(zone)
+		if err != nil || zone == "Local" {
+			return parseError("Die Zeitzone %v kenne ich nicht, bitte gib sie z.B. als Europe/Berlin oder America/New_York an.", zone)
+		}
+		zone = loc.String()
+	}
+
+	if err := updateUser(message.From.ID, func(u *user) { u.TimeZone = zone }); err != nil {
+		return err
+	}
+	if zone == "" {
+		message.Reply("Es gilt wieder die Zeitzone deines Kalenders.")
+	} else {
+		message.Replyf("Deine Zeitzone ist jetzt %v.", zone)
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clndr/parser"
+	"github.com/yanzay/tbot"
+	"google.golang.org/api/calendar/v3"
+)
+
+const todosBucket = "todos"
+
+// todo is an entry of the todo list of a chat.
+type todo struct {
+	ID      int       `json:"id"`
+	Text    string    `json:"text"`
+	Due     string    `json:"due,omitempty"` // 2006-01-02, empty without due date
+	Done    bool      `json:"done,omitempty"`
+	Created time.Time `json:"created"`
+
+	// the all-day event mirroring the due date, if any
+	CalendarID string `json:"calendar_id,omitempty"`
+	EventID    string `json:"event_id,omitempty"`
+}
+
+// todoList is the todo list of a chat.
+type todoList struct {
+	Items  []*todo `json:"items"`
+	NextID int     `json:"next_id"`
+	Mirror bool    `json:"mirror,omitempty"` // add due dates to the calendar
+}
+
+// find returns the todo with the given number, as shown by /todo list.
+func (l *todoList) find(ref string) (*todo, int, error) {
+	id, err := strconv.Atoi(strings.Trim(ref, "[]#"))
+	if err == nil {
+		for i, t := range l.Items {
+			if t.ID == id {
+				return t, i, nil
+			}
+		}
+	}
+	return nil, 0, notFoundError("Die Aufgabe %v kenne ich nicht, schau mit /todo list nach.", ref)
+}
+
+// TodoStore keeps the todo lists of chats.
+type TodoStore interface {
+	// Load returns the todo list of a chat, an empty one if it has none yet.
+	Load(chatID int64) (*todoList, error)
+	// Save stores the todo list of a chat.
+	Save(chatID int64, list *todoList) error
+}
+
+// bucketTodoStore is a TodoStore keeping each list as one value of a Store bucket.
+type bucketTodoStore struct {
+	store Store
+}
+
+func newTodoStore(store Store) *bucketTodoStore {
+	return &bucketTodoStore{store: store}
+}
+
+func (s *bucketTodoStore) Load(chatID int64) (*todoList, error) {
+	list := &todoList{NextID: 1}
+	_, err := s.store.Get(todosBucket, strconv.FormatInt(chatID, 10), list)
+	return list, err
+}
+
+func (s *bucketTodoStore) Save(chatID int64, list *todoList) error {
+	return s.store.Put(todosBucket, strconv.FormatInt(chatID, 10), list)
+}
+
+// todosMu serializes read-modify-write cycles on todo lists.
+var todosMu sync.Mutex
+
+// updateTodos applies change to the todo list of a chat and stores it.
+func updateTodos(chatID int64, change func(*todoList) error) error {
+	todosMu.Lock()
+	defer todosMu.Unlock()
+
+	list, err := todos.Load(chatID)
+	if err != nil {
+		return err
+	}
+	if err := change(list); err != nil {
+		return err
+	}
+	return todos.Save(chatID, list)
+}
+
+const todoUsage = "So verwaltest du die Aufgaben dieses Chats:\n" +
+	"/todo add Steuererklärung bis 31.05. - Aufgabe hinzufügen, das Fälligkeitsdatum ist optional\n" +
+	"/todo list - offene und erledigte Aufgaben zeigen\n" +
+	"/todo done 3 - Aufgabe erledigen\n" +
+	"/todo remove 3 - Aufgabe löschen\n" +
+	"/todo kalender an|aus - Fälligkeitsdaten als ganztägige Termine in den Kalender eintragen"
+
+// dueMarkers introduce the due date of a todo, e.g. "bis 31.05." or
+// "due friday". Dates elsewhere in a todo are part of its text.
+var dueMarkers = map[string]bool{"bis": true, "fällig": true, "due": true}
+
+// dueFillers may follow a due marker, e.g. "fällig am 31.05.".
+var dueFillers = map[string]bool{"am": true, "zum": true, "on": true, "by": true}
+
+// TodoHandler manages the todo list of a chat.
+func TodoHandler(message *tbot.Message) error {
+	args := strings.SplitN(strings.TrimSpace(message.Vars["args"]), " ", 2)
+	arg := ""
+	if len(args) == 2 {
+		arg = strings.TrimSpace(args[1])
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "add", "neu":
+		return addTodo(message, arg)
+	case "", "list", "liste":
+		return listTodos(message)
+	case "done", "erledigt":
+		return finishTodo(message, arg)
+	case "remove", "delete", "löschen":
+		return removeTodo(message, arg)
+	case "kalender", "calendar":
+		return mirrorTodos(message, arg)
+	}
+	return parseError(todoUsage)
+}
+
+func addTodo(message *tbot.Message, text string) error {
+	if text == "" {
+		return parseError("Bitte gib die Aufgabe an, z.B. /todo add Steuererklärung bis 31.05.")
+	}
+	loc := todoLocation(message.From.ID)
+
+	t := &todo{Created: time.Now()}
+	t.Text, t.Due = splitDue(text, time.Now().In(loc))
+
+	//the event is added before taking todosMu, so other chats do not wait for the calendar
+	todosMu.Lock()
+	current, err := todos.Load(message.ChatID)
+	todosMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if current.Mirror && t.Due != "" {
+		if err := mirrorTodo(message.ChatID, message.From.ID, t); err != nil {
+			return err
+		}
+	}
+
+	err = updateTodos(message.ChatID, func(list *todoList) error {
+		t.ID = list.NextID
+		list.NextID++
+		list.Items = append(list.Items, t)
+		return nil
+	})
+	if err != nil {
+		unmirrorTodo(message.ChatID, message.From.ID, t)
+		return err
+	}
+
+	reply := fmt.Sprintf("Aufgabe [%v] %v hinzugefügt", t.ID, t.Text)
+	if t.Due != "" {
+		reply += ", fällig " + formatDue(t.Due)
+	}
+	if t.EventID != "" {
+		reply += " (im Kalender eingetragen)"
+	}
+	message.Reply(reply)
+	return nil
+}
+
+func listTodos(message *tbot.Message) error {
+	todosMu.Lock()
+	list, err := todos.Load(message.ChatID)
+	todosMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(list.Items) == 0 {
+		message.Reply("Keine Aufgaben. Neue fügst du mit /todo add hinzu.")
+		return nil
+	}
+
+	today := time.Now().In(todoLocation(message.From.ID)).Format("2006-01-02")
+	var open, done []string
+	for _, t := range list.Items {
+		line := fmt.Sprintf("[%v] %v", t.ID, t.Text)
+		switch {
+		case t.Done:
+			done = append(done, "✓ "+line)
+			continue
+		case t.Due != "" && t.Due < today:
+			line += " (überfällig seit " + formatDue(t.Due) + ")"
+		case t.Due != "":
+			line += " (fällig " + formatDue(t.Due) + ")"
+		}
+		open = append(open, line)
+	}
+
+	reply := "Offene Aufgaben:\n" + strings.Join(open, "\n")
+	if len(open) == 0 {
+		reply = "Keine offenen Aufgaben."
+	}
+	if len(done) > 0 {
+		reply += "\n\nErledigt:\n" + strings.Join(done, "\n")
+	}
+	message.Reply(reply)
+	return nil
+}
+
+func finishTodo(message *tbot.Message, ref string) error {
+	var t todo
+	err := updateTodos(message.ChatID, func(list *todoList) error {
+		found, _, err := list.find(ref)
+		if err != nil {
+			return err
+		}
+		found.Done = true
+		t = *found
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	//the event is removed after releasing todosMu and then forgotten
+	if t.EventID != "" {
+		unmirrorTodo(message.ChatID, message.From.ID, &t)
+		if t.EventID == "" {
+			err = updateTodos(message.ChatID, func(list *todoList) error {
+				if found, _, err := list.find(strconv.Itoa(t.ID)); err == nil {
+					found.CalendarID, found.EventID = "", ""
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	message.Replyf("Aufgabe [%v] %v erledigt", t.ID, t.Text)
+	return nil
+}
+
+func removeTodo(message *tbot.Message, ref string) error {
+	var t *todo
+	err := updateTodos(message.ChatID, func(list *todoList) error {
+		var i int
+		var err error
+		if t, i, err = list.find(ref); err != nil {
+			return err
+		}
+		list.Items = append(list.Items[:i], list.Items[i+1:]...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	unmirrorTodo(message.ChatID, message.From.ID, t)
+	message.Replyf("Aufgabe [%v] %v gelöscht", t.ID, t.Text)
+	return nil
+}
+
+// mirrorTodos switches adding the due dates of new todos to the calendar.
+func mirrorTodos(message *tbot.Message, arg string) error {
+	var mirror bool
+	switch strings.ToLower(arg) {
+	case "an", "on", "ja":
+		mirror = true
+		if _, _, err := chatBackend(message.ChatID, message.From.ID); err != nil {
+			return err
+		}
+	case "aus", "off", "nein":
+	default:
+		return parseError("Bitte sende /todo kalender an oder /todo kalender aus.")
+	}
+
+	err := updateTodos(message.ChatID, func(list *todoList) error {
+		list.Mirror = mirror
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if mirror {
+		message.Reply("Aufgaben mit Fälligkeitsdatum trage ich ab jetzt als ganztägige Termine in den Kalender ein.")
+	} else {
+		message.Reply("Aufgaben trage ich nicht mehr in den Kalender ein.")
+	}
+	return nil
+}
+
+// mirrorTodo adds the due date of t as all-day event to the calendar of the chat.
+func mirrorTodo(chatID int64, userID int, t *todo) error {
+	backend, calendarID, err := chatBackend(chatID, userID)
+	if err != nil {
+		return err
+	}
+	due, _ := time.Parse("2006-01-02", t.Due)
+	created, err := backend.Insert(calendarID, &calendar.Event{
+		Summary: "Aufgabe: " + t.Text,
+		Start:   newDateTime(due, true),
+		End:     newDateTime(due.AddDate(0, 0, 1), true),
+	}, "")
+	if err != nil {
+		return err
+	}
+	t.CalendarID = calendarID
+	t.EventID = created.Id
+	return nil
+}
+
+// unmirrorTodo removes the event of a finished or deleted todo from the
+// calendar. The todo list is what counts, so failures are only logged.
+func unmirrorTodo(chatID int64, userID int, t *todo) {
+	if t.EventID == "" {
+		return
+	}
+	backend, _, err := chatBackend(chatID, userID)
+	if err == nil {
+		err = backend.Delete(t.CalendarID, t.EventID, "")
+	}
+	if err != nil && classify(err).kind != kindNotFound {
+		log.Printf("Error removing event %v of todo %v: %v", t.EventID, t.ID, err)
+		return
+	}
+	t.CalendarID = ""
+	t.EventID = ""
+}
+
+// todoLocation returns the time zone due dates are entered in. Todos
+// work without a connected calendar, so it falls back to the server's zone.
+func todoLocation(userID int) *time.Location {
+	if backend, err := backendFor(userID); err == nil {
+		if loc, err := userLocation(userID, backend, ""); err == nil {
+			return loc
+		}
+	}
+	if u, err := loadUser(userID); err == nil && u.TimeZone != "" {
+		if loc, err := time.LoadLocation(u.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// splitDue splits the due date after the last due marker off a todo and
+// returns the text and the due date, e.g. "Steuererklärung" and 2025-05-31
+// for "Steuererklärung bis 31.05.". Without marker there is no due date.
+func splitDue(text string, now time.Time) (string, string) {
+	words := strings.Fields(text)
+	for i := len(words) - 2; i > 0; i-- {
+		if !dueMarkers[strings.ToLower(words[i])] {
+			continue
+		}
+		rest := words[i+1:]
+		if len(rest) > 1 && dueFillers[strings.ToLower(rest[0])] {
+			rest = rest[1:]
+		}
+		parsed, err := parser.ParseTime(strings.Join(rest, " "), now)
+		if err == nil && parsed.Name == "" && len(parsed.Recurrence) == 0 {
+			return strings.Join(words[:i], " "), parsed.Start.Format("2006-01-02")
+		}
+	}
+	return text, ""
+}
+
+// formatDue shows a due date like the dates of events.
+func formatDue(due string) string {
+	d, err := time.Parse("2006-01-02", due)
+	if err != nil {
+		return due
+	}
+	return d.Format(dateFormat)
+}
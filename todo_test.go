@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yanzay/tbot"
+)
+
+func TestSplitDue(t *testing.T) {
+	now := time.Date(2025, 3, 12, 11, 17, 0, 0, testLocation(t))
+	tests := []struct {
+		input string
+		text  string
+		due   string
+	}{
+		{"Steuererklärung bis 31.05.2025", "Steuererklärung", "2025-05-31"},
+		{"Bericht fällig am 20.03.", "Bericht", "2025-03-20"},
+		{"Präsentation due friday", "Präsentation", "2025-03-14"},
+		{"Blumen gießen bis morgen", "Blumen gießen", "2025-03-13"},
+		//dates without marker are part of the todo
+		{"Read chapter 3", "Read chapter 3", ""},
+		{"Call mom at 5pm", "Call mom at 5pm", ""},
+		{"Zahnarzt morgen anrufen", "Zahnarzt morgen anrufen", ""},
+		{"Warten bis Anna antwortet", "Warten bis Anna antwortet", ""},
+		{"bis morgen", "bis morgen", ""},
+	}
+
+	for _, tt := range tests {
+		text, due := splitDue(tt.input, now)
+		if text != tt.text || due != tt.due {
+			t.Errorf("splitDue(%q) = %q, %q, want %q, %q", tt.input, text, due, tt.text, tt.due)
+		}
+	}
+}
+
+func TestTodoMirror(t *testing.T) {
+	setupHandlers(t)
+	todo := func(args string) string {
+		return sendOne(t, TodoHandler, tbot.MessageVars{"args": args})
+	}
+
+	todo("kalender an")
+	wantContains(t, todo("add Steuererklärung bis 31.05.2030"), "[1] Steuererklärung hinzugefügt, fällig 31/05/2030 (im Kalender eingetragen)")
+	wantContains(t, todo("add Read chapter 3"), "[2] Read chapter 3 hinzugefügt")
+	due := time.Date(2030, 5, 31, 0, 0, 0, 0, testLocation(t))
+	if events, _ := memory.Between("primary", due, due.AddDate(0, 0, 1), 10); len(events) != 1 {
+		t.Fatalf("%v events on the due date, want 1", len(events))
+	}
+
+	wantContains(t, todo("done 1"), "[1] Steuererklärung erledigt")
+	if events, _ := memory.Between("primary", due, due.AddDate(0, 0, 1), 10); len(events) != 0 {
+		t.Errorf("the event of a finished todo is still there")
+	}
+	list, err := todos.Load(testChat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := list.Items[0]; !first.Done || first.EventID != "" {
+		t.Errorf("finished todo is stored as %+v", first)
+	}
+	wantContains(t, todo("list"), "Offene Aufgaben:\n[2] Read chapter 3", "Erledigt:\n✓ [1] Steuererklärung")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+const usersBucket = "users"
+
+// user is what the bot remembers about a Telegram user.
+type user struct {
+	ID        int           `json:"id"`
+	ChatID    int64         `json:"chat_id"` // private chat with the bot
+	Token     *oauth2.Token `json:"token,omitempty"`
+	TimeZone  string        `json:"time_zone,omitempty"`  // IANA name, the calendar's zone if empty
+	Reminder  *int          `json:"reminder,omitempty"`   // minutes before events, the default if nil, none if negative
+	Calendar  string        `json:"calendar,omitempty"`   // calendar ID chosen with /use, CALENDARID if empty
+	WorkHours string        `json:"work_hours,omitempty"` // like "09:00-17:00", WORKHOURS if empty
+	Email     string        `json:"email,omitempty"`      // address of the linked Google account
+}
+
+// calendar returns the ID of the calendar the user works with by default.
+func (u *user) calendar() string {
+	if u.Calendar == "" {
+		return calendarId
+	}
+	return u.Calendar
+}
+
+// usersMu serializes read-modify-write cycles on user records.
+var usersMu sync.Mutex
+
+// loadUser returns the stored record of a Telegram user,
+// or an empty record if the user is unknown.
+func loadUser(userID int) (*user, error) {
+	u := &user{ID: userID}
+	_, err := store.Get(usersBucket, strconv.Itoa(userID), u)
+	return u, err
+}
+
+// updateUser applies change to the record of a Telegram user and stores it.
+func updateUser(userID int, change func(*user)) error {
+	usersMu.Lock()
+	defer usersMu.Unlock()
+
+	u, err := loadUser(userID)
+	if err != nil {
+		return err
+	}
+	change(u)
+	return store.Put(usersBucket, strconv.Itoa(userID), u)
+}
+
+// allUsers returns the records of all users the bot knows.
+func allUsers() ([]*user, error) {
+	keys, err := store.Keys(usersBucket)
+	if err != nil {
+		return nil, err
+	}
+	var users []*user
+	for _, key := range keys {
+		u := &user{}
+		if _, err := store.Get(usersBucket, key, u); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// linkedUsers returns all users with a connected Google account.
+func linkedUsers() ([]*user, error) {
+	users, err := allUsers()
+	if err != nil {
+		return nil, err
+	}
+	var linked []*user
+	for _, u := range users {
+		if u.Token != nil {
+			linked = append(linked, u)
+		}
+	}
+	return linked, nil
+}
+
+// userEmail returns the address of the Google account a user linked,
+// which is the ID of their primary calendar. It is looked up once and
+// then remembered.
+func userEmail(userID int) (string, error) {
+	u, err := loadUser(userID)
+	if err != nil || u.Email != "" {
+		return u.Email, err
+	}
+	backend, err := backendFor(userID)
+	if err != nil {
+		return "", err
+	}
+	entries, err := backend.Calendars()
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.Primary && strings.Contains(entry.Id, "@") {
+			return entry.Id, updateUser(userID, func(u *user) { u.Email = entry.Id })
+		}
+	}
+	return "", notFoundError("Die E-Mail Adresse des Google Kontos von Nutzer %v kenne ich nicht.", userID)
+}
+
+// storingTokenSource wraps a TokenSource and persists every
+// refreshed token of the user, so refreshes survive a restart.
+type storingTokenSource struct {
+	userID int
+	src    oauth2.TokenSource
+
+	mu   sync.Mutex
+	last string // access token stored last
+}
+
+func newStoringTokenSource(userID int, tok *oauth2.Token, src oauth2.TokenSource) *storingTokenSource {
+	return &storingTokenSource{userID: userID, src: src, last: tok.AccessToken}
+}
+
+func (s *storingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tok.AccessToken != s.last {
+		err := updateUser(s.userID, func(u *user) {
+			if tok.RefreshToken == "" && u.Token != nil {
+				tok.RefreshToken = u.Token.RefreshToken
+			}
+			u.Token = tok
+		})
+		if err != nil {
+			log.Printf("Error storing refreshed token of user %v: %v", s.userID, err)
+		} else {
+			s.last = tok.AccessToken
+		}
+	}
+	return tok, nil
+}
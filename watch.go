@@ -0,0 +1,498 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yanzay/tbot"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	channelsBucket = "channels" // channel ID -> watchChannel
+	watchesBucket  = "watches"  // chat ID -> watch
+
+	// notificationPath is where the calendar API pushes notifications to.
+	notificationPath = "/notifications"
+	// channelTTL is how long a channel is requested to stay open.
+	channelTTL = 7 * 24 * time.Hour
+	// channelRenewal is how long before its expiration a channel is replaced.
+	channelRenewal = 24 * time.Hour
+	// renewInterval is how often channels are checked for renewal.
+	renewInterval = time.Hour
+)
+
+// publicURL is the https address the bot's HTTP server is reachable at from
+// the internet, without trailing slash. Push notifications need it.
+var publicURL string
+
+// watch is the subscription of a chat to changes of a user's calendar.
+type watch struct {
+	ChatID     int64  `json:"chat_id"`
+	UserID     int    `json:"user_id"`
+	CalendarID string `json:"calendar_id"`
+}
+
+// watchChannel is an open notification channel of a user's calendar with
+// the versions of its upcoming events as last seen, to tell what changed.
+type watchChannel struct {
+	ID         string            `json:"id"`
+	ResourceID string            `json:"resource_id"`
+	Token      string            `json:"token"` // secret the calendar API sends back with each notification
+	Expiration time.Time         `json:"expiration"`
+	UserID     int               `json:"user_id"`
+	CalendarID string            `json:"calendar_id"`
+	SyncToken  string            `json:"sync_token"`
+	Etags      map[string]string `json:"etags"` // event ID -> etag of the upcoming events
+}
+
+// channelsMu serializes changes of channels, as notifications for a
+// channel may arrive while the previous one is still processed.
+var channelsMu sync.Mutex
+
+// WatchHandler makes the bot post changes of the user's calendar to the
+// chat for /watch or /watch in:<calendar>, and stops that for /watch aus.
+func WatchHandler(message *tbot.Message) error {
+	args, calName := takeCalendar(strings.TrimSpace(message.Vars["args"]))
+	if isOff(args) {
+		return unwatch(message)
+	}
+	if publicURL == "" {
+		return &botError{kind: kindAPI, msg: "Benachrichtigungen über Änderungen sind auf diesem Server nicht eingerichtet."}
+	}
+	owner, err := chatOwner(message.ChatID, message.From.ID)
+	if err != nil {
+		return err
+	}
+	backend, calendarID, err := chatBackend(message.ChatID, message.From.ID)
+	if err != nil {
+		return err
+	}
+	watcher, ok := backend.(Watcher)
+	if !ok {
+		return &botError{kind: kindAPI, msg: "Dieser Kalender kann keine Änderungen melden."}
+	}
+	calendarID, calName, err = targetCalendar(message.ChatID, backend, calendarID, calName)
+	if err != nil {
+		return err
+	}
+
+	channelsMu.Lock()
+	_, err = userChannel(owner, calendarID)
+	if err == errNoChannel {
+		_, err = openChannel(watcher, owner, calendarID)
+	}
+	channelsMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	//a chat watches one calendar, the one watched before may not be needed anymore
+	key := strconv.FormatInt(message.ChatID, 10)
+	previous := watch{}
+	ok, err = store.Get(watchesBucket, key, &previous)
+	if err != nil {
+		return err
+	}
+	w := watch{ChatID: message.ChatID, UserID: owner, CalendarID: calendarID}
+	if err := store.Put(watchesBucket, key, w); err != nil {
+		return err
+	}
+	if ok && previous != w {
+		releaseChannel(previous)
+	}
+
+	switch {
+	case calName != "":
+	case owner != message.From.ID:
+		calName = "dem Kalender der Gruppe"
+	default:
+		calName = "deinem Kalender"
+	}
+	message.Replyf("Ich melde Änderungen an %v ab jetzt in diesem Chat. Abbestellen kannst du das mit /watch aus.", calName)
+	return nil
+}
+
+// unwatch ends the subscription of a chat and closes the channel
+// of the user if no other chat needs it.
+func unwatch(message *tbot.Message) error {
+	key := strconv.FormatInt(message.ChatID, 10)
+	w := watch{}
+	ok, err := store.Get(watchesBucket, key, &w)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		message.Reply("Dieser Chat bekommt keine Änderungen gemeldet.")
+		return nil
+	}
+	if err := store.Delete(watchesBucket, key); err != nil {
+		return err
+	}
+	releaseChannel(w)
+	message.Reply("Ich melde keine Änderungen mehr in diesem Chat.")
+	return nil
+}
+
+// releaseChannel closes the channel of a watch that ended if no other
+// chat watches the same calendar.
+func releaseChannel(w watch) {
+	chats, err := watchingChats(w.UserID, w.CalendarID)
+	if err == nil && len(chats) == 0 {
+		channelsMu.Lock()
+		err = closeChannel(w.UserID, w.CalendarID)
+		channelsMu.Unlock()
+	}
+	if err != nil {
+		log.Printf("Error closing channel of user %v: %v", w.UserID, err)
+	}
+}
+
+// watchingChats returns the chats that watch a calendar of a user.
+func watchingChats(userID int, calendarID string) ([]int64, error) {
+	keys, err := store.Keys(watchesBucket)
+	if err != nil {
+		return nil, err
+	}
+	var chats []int64
+	for _, key := range keys {
+		w := watch{}
+		if _, err := store.Get(watchesBucket, key, &w); err != nil {
+			return nil, err
+		}
+		if w.UserID == userID && w.CalendarID == calendarID {
+			chats = append(chats, w.ChatID)
+		}
+	}
+	return chats, nil
+}
+
+var errNoChannel = fmt.Errorf("no notification channel")
+
+// allChannels returns all open channels.
+func allChannels() ([]*watchChannel, error) {
+	keys, err := store.Keys(channelsBucket)
+	if err != nil {
+		return nil, err
+	}
+	var channels []*watchChannel
+	for _, key := range keys {
+		c := &watchChannel{}
+		if _, err := store.Get(channelsBucket, key, c); err != nil {
+			return nil, err
+		}
+		channels = append(channels, c)
+	}
+	return channels, nil
+}
+
+// userChannel returns the open channel of a user's calendar, or errNoChannel.
+func userChannel(userID int, calendarID string) (*watchChannel, error) {
+	channels, err := allChannels()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range channels {
+		if c.UserID == userID && c.CalendarID == calendarID {
+			return c, nil
+		}
+	}
+	return nil, errNoChannel
+}
+
+// openChannel asks for notifications about the user's calendar
+// and remembers the versions of its upcoming events.
+func openChannel(watcher Watcher, userID int, calendarID string) (*watchChannel, error) {
+	now := time.Now()
+	events, syncToken, err := watcher.Changes(calendarID, "", now)
+	if err != nil {
+		return nil, err
+	}
+	c := &watchChannel{UserID: userID, CalendarID: calendarID, SyncToken: syncToken, Etags: make(map[string]string)}
+	for _, evt := range events {
+		if evt.Status != "cancelled" && eventTime(evt.End).After(now) {
+			c.Etags[evt.Id] = evt.Etag
+		}
+	}
+	return c, startChannel(watcher, c)
+}
+
+// startChannel opens c at the calendar API under a new ID and stores it.
+func startChannel(watcher Watcher, c *watchChannel) error {
+	var err error
+	if c.ID, err = randomHex(16); err != nil {
+		return err
+	}
+	if c.Token, err = randomHex(16); err != nil {
+		return err
+	}
+	opened, err := watcher.Watch(c.CalendarID, &calendar.Channel{
+		Id:         c.ID,
+		Type:       "web_hook",
+		Address:    publicURL + notificationPath,
+		Token:      c.Token,
+		Expiration: time.Now().Add(channelTTL).UnixNano() / int64(time.Millisecond),
+	})
+	if err != nil {
+		return err
+	}
+	c.ResourceID = opened.ResourceId
+	c.Expiration = time.Unix(0, opened.Expiration*int64(time.Millisecond))
+	return store.Put(channelsBucket, c.ID, c)
+}
+
+// closeChannel stops the notifications about the user's calendar.
+func closeChannel(userID int, calendarID string) error {
+	c, err := userChannel(userID, calendarID)
+	if err == errNoChannel {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(channelsBucket, c.ID); err != nil {
+		return err
+	}
+	backend, err := backendFor(userID)
+	if err != nil {
+		return err
+	}
+	if watcher, ok := backend.(Watcher); ok {
+		return watcher.StopWatch(c.ID, c.ResourceID)
+	}
+	return nil
+}
+
+// notificationHandler receives the push notifications of the calendar API.
+// They only tell that something changed, the changes are fetched afterwards.
+func notificationHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get("X-Goog-Channel-ID")
+	c := &watchChannel{}
+	ok, err := store.Get(channelsBucket, id, c)
+	if err != nil || !ok || c.Token != r.Header.Get("X-Goog-Channel-Token") {
+		//unknown channels get no error, the calendar API would only retry
+		log.Printf("Ignoring notification of unknown channel %q", id)
+		return
+	}
+	//the first notification only confirms the channel
+	if r.Header.Get("X-Goog-Resource-State") == "sync" {
+		return
+	}
+	go func() {
+		if err := processChanges(id); err != nil {
+			log.Printf("Error processing changes of channel %v: %v", id, err)
+		}
+	}()
+}
+
+// processChanges fetches the changes of the calendar behind a channel
+// and posts them to the chats watching it.
+func processChanges(channelID string) error {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+
+	c := &watchChannel{}
+	if ok, err := store.Get(channelsBucket, channelID, c); err != nil || !ok {
+		return err
+	}
+	if c.Etags == nil {
+		c.Etags = make(map[string]string)
+	}
+	invalidateCache(c.UserID, c.CalendarID)
+	backend, err := backendFor(c.UserID)
+	if err != nil {
+		return err
+	}
+	watcher, ok := backend.(Watcher)
+	if !ok {
+		return nil
+	}
+
+	events, syncToken, err := watcher.Changes(c.CalendarID, c.SyncToken, time.Time{})
+	full := false
+	if err != nil && isGone(err) {
+		//the sync token expired (410 Gone), compare with all upcoming events instead
+		events, syncToken, err = watcher.Changes(c.CalendarID, "", time.Now())
+		full = true
+	}
+	if err != nil {
+		return err
+	}
+
+	loc, err := userLocation(c.UserID, backend, c.CalendarID)
+	if err != nil {
+		return err
+	}
+	previous := func(eventID string) *calendar.Event {
+		return cachedEvent(c.UserID, c.CalendarID, eventID)
+	}
+	changes := diffEvents(c.Etags, previous, events, full, loc)
+	c.SyncToken = syncToken
+	if err := store.Put(channelsBucket, c.ID, c); err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	chats, err := watchingChats(c.UserID, c.CalendarID)
+	if err != nil {
+		return err
+	}
+	text := "Änderungen im Kalender:\n" + strings.Join(changes, "\n")
+	for _, chatID := range chats {
+		if err := bot.Send(chatID, text); err != nil {
+			log.Printf("Error sending changes to chat %v: %v", chatID, err)
+		}
+	}
+	return nil
+}
+
+// diffEvents updates the etags of the known upcoming events with changed
+// ones and describes each change. What changed is told from the previous
+// version of an event, if previous still has the one with the known etag.
+// If full is set, changed holds all upcoming events and known events
+// missing from it were deleted or have ended.
+func diffEvents(known map[string]string, previous func(eventID string) *calendar.Event, changed []*calendar.Event, full bool, loc *time.Location) []string {
+	//the previous version is only of use if it is the one last seen
+	lastSeen := func(eventID string) *calendar.Event {
+		if old := previous(eventID); old != nil && old.Etag == known[eventID] {
+			return old
+		}
+		return nil
+	}
+
+	var changes []string
+	seen := make(map[string]bool)
+	now := time.Now()
+	for _, evt := range changed {
+		seen[evt.Id] = true
+		etag, ok := known[evt.Id]
+		switch {
+		case evt.Status == "cancelled":
+			if old := lastSeen(evt.Id); old != nil {
+				changes = append(changes, fmt.Sprintf("- %v (%v) wurde abgesagt", old.Summary, formatEventTime(old, loc)))
+			} else if ok {
+				changes = append(changes, "- Ein Termin wurde abgesagt")
+			}
+			delete(known, evt.Id)
+			continue
+		case !eventTime(evt.End).After(now):
+			delete(known, evt.Id)
+			continue
+		case !ok:
+			changes = append(changes, fmt.Sprintf("- Neu: %v (%v)", evt.Summary, formatEventTime(evt, loc)))
+		case etag == evt.Etag:
+		default:
+			if old := lastSeen(evt.Id); old != nil {
+				changes = append(changes, describeChanges(old, evt, loc)...)
+			} else {
+				changes = append(changes, fmt.Sprintf("- %v (%v) wurde geändert", evt.Summary, formatEventTime(evt, loc)))
+			}
+		}
+		known[evt.Id] = evt.Etag
+	}
+	if full {
+		for id := range known {
+			if seen[id] {
+				continue
+			}
+			if old := lastSeen(id); old != nil && eventTime(old.End).After(now) {
+				changes = append(changes, fmt.Sprintf("- %v (%v) wurde abgesagt", old.Summary, formatEventTime(old, loc)))
+			}
+			delete(known, id)
+		}
+	}
+	return changes
+}
+
+// describeChanges tells what changed between two versions of an event,
+// e.g. "Standup am 17/03/2025 verschoben von 09:00 auf 09:30".
+func describeChanges(old, evt *calendar.Event, loc *time.Location) []string {
+	var changes []string
+	if old.Summary != evt.Summary {
+		changes = append(changes, fmt.Sprintf("- %v heißt jetzt %v", old.Summary, evt.Summary))
+	}
+	oldTime, newTime := formatEventTime(old, loc), formatEventTime(evt, loc)
+	if oldTime != newTime {
+		oldStart, newStart := eventStart(old, loc).In(loc), eventStart(evt, loc).In(loc)
+		if evt.Start.DateTime != "" && old.Start.DateTime != "" && sameDay(oldStart, newStart) {
+			changes = append(changes, fmt.Sprintf("- %v am %v verschoben von %v auf %v", evt.Summary, newStart.Format(dateFormat),
+				formatAgendaTime(old, loc), formatAgendaTime(evt, loc)))
+		} else {
+			changes = append(changes, fmt.Sprintf("- %v verschoben von %v auf %v", evt.Summary, oldTime, newTime))
+		}
+	}
+	if old.Location != evt.Location {
+		changes = append(changes, fmt.Sprintf("- %v findet jetzt statt in: %v", evt.Summary, evt.Location))
+	}
+	return changes
+}
+
+// startChannelRenewal replaces channels before they expire, in the background.
+func startChannelRenewal() {
+	if publicURL == "" {
+		return
+	}
+	go func() {
+		for range time.Tick(renewInterval) {
+			if err := renewChannels(); err != nil {
+				log.Printf("Error renewing channels: %v", err)
+			}
+		}
+	}()
+}
+
+// renewChannels opens a new channel for each channel about to expire and
+// closes the old one, keeping the known events and sync token.
+func renewChannels() error {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+
+	channels, err := allChannels()
+	if err != nil {
+		return err
+	}
+	for _, c := range channels {
+		if time.Until(c.Expiration) > channelRenewal {
+			continue
+		}
+		backend, err := backendFor(c.UserID)
+		if err != nil {
+			log.Printf("Error renewing channel of user %v: %v", c.UserID, err)
+			continue
+		}
+		watcher, ok := backend.(Watcher)
+		if !ok {
+			continue
+		}
+		oldID, oldResource := c.ID, c.ResourceID
+		if err := startChannel(watcher, c); err != nil {
+			log.Printf("Error renewing channel of user %v: %v", c.UserID, err)
+			continue
+		}
+		if err := store.Delete(channelsBucket, oldID); err != nil {
+			return err
+		}
+		if err := watcher.StopWatch(oldID, oldResource); err != nil {
+			log.Printf("Error stopping channel %v: %v", oldID, err)
+		}
+	}
+	return nil
+}
+
+// randomHex returns n random bytes as hex string.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
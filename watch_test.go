@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestDiffEvents(t *testing.T) {
+	loc := testLocation(t)
+	day := time.Now().In(loc).AddDate(0, 0, 2)
+	at := func(hour, minute int) *calendar.EventDateTime {
+		return newDateTime(time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc), false)
+	}
+	event := func(id, etag, summary string, hour, minute int) *calendar.Event {
+		return &calendar.Event{Id: id, Etag: etag, Summary: summary, Start: at(hour, minute), End: at(hour+1, minute)}
+	}
+	cached := map[string]*calendar.Event{
+		"standup": event("standup", "1", "Standup", 9, 0),
+		"review":  event("review", "1", "Review", 14, 0),
+		"retro":   event("retro", "1", "Retro", 16, 0),
+		//the cache already has a newer version than the one last seen
+		"sync": event("sync", "2", "Sync", 17, 0),
+	}
+	previous := func(id string) *calendar.Event { return cached[id] }
+
+	tests := []struct {
+		name    string
+		changed []*calendar.Event
+		full    bool
+		want    []string
+	}{
+		{"moved", []*calendar.Event{event("standup", "2", "Standup", 9, 30)}, false, []string{"Standup am", "verschoben von 09:00-10:00 auf 09:30-10:30"}},
+		{"cancelled", []*calendar.Event{{Id: "review", Status: "cancelled"}}, false, []string{"Review (", "wurde abgesagt"}},
+		{"new", []*calendar.Event{event("planning", "1", "Planung", 11, 0)}, false, []string{"Neu: Planung"}},
+		{"unchanged", []*calendar.Event{event("retro", "1", "Retro", 16, 0)}, false, nil},
+		{"cache ahead", []*calendar.Event{event("sync", "3", "Sync", 17, 30)}, false, []string{"Sync (", "wurde geändert"}},
+		{"gone in full sync", []*calendar.Event{event("review", "1", "Review", 14, 0)}, true, []string{"Retro (", "wurde abgesagt"}},
+	}
+	for _, tt := range tests {
+		known := map[string]string{"standup": "1", "review": "1", "retro": "1", "sync": "1"}
+		changes := diffEvents(known, previous, tt.changed, tt.full, loc)
+		got := strings.Join(changes, "\n")
+		if (tt.want == nil) != (len(changes) == 0) {
+			t.Errorf("%v: changes %q, want %q", tt.name, got, tt.want)
+		}
+		for _, part := range tt.want {
+			if !strings.Contains(got, part) {
+				t.Errorf("%v: changes %q do not contain %q", tt.name, got, part)
+			}
+		}
+		for _, evt := range tt.changed {
+			if etag, ok := known[evt.Id]; evt.Status != "cancelled" && etag != evt.Etag || evt.Status == "cancelled" && ok {
+				t.Errorf("%v: known etag of %v is %q after the change", tt.name, evt.Id, etag)
+			}
+		}
+	}
+}